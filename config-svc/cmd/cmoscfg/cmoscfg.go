@@ -18,8 +18,10 @@ import (
 	"flag"
 	"log"
 	"os"
+	"time"
 
 	"github.com/couchbaselabs/observability/config-svc/pkg/api"
+	"github.com/couchbaselabs/observability/config-svc/pkg/couchbase"
 	"go.uber.org/zap"
 )
 
@@ -28,6 +30,35 @@ var (
 	flagHTTPHost       = flag.String("http-host", "0.0.0.0", "host to listen on")
 	flagHTTPPort       = flag.Int("http-port", 7194, "port to listen on")
 	flagDevelopment    = flag.Bool("development", false, "enable development logging and file paths")
+
+	flagReconcileInterval = flag.Duration("reconcile-interval", 5*time.Minute,
+		"how often to check managed clusters for topology changes (0 to disable)")
+	flagTargetMode = flag.String("target-mode", string(api.TargetModePrometheusConfig),
+		"how to tell Prometheus about managed clusters: prometheus-config to write them into its config file, "+
+			"http-sd to serve them from the service discovery endpoints, or file-sd to write file_sd JSON files "+
+			"(http-sd and file-sd scrape every cluster with the credentials of the Prometheus job)")
+	flagManagedConfigFile = flag.String("managed-config-file", "/etc/cmos/managed-clusters.yml",
+		"where to keep the managed clusters in http-sd and file-sd modes")
+	flagFileSDDir = flag.String("file-sd-dir", "/etc/prometheus/couchbase/custom",
+		"directory to write file_sd JSON files to in file-sd mode")
+	flagPrometheusURL = flag.String("prometheus-url", "http://localhost:9090",
+		"URL of Prometheus, to reload it after changing its config file (empty to disable)")
+	flagPromtool = flag.String("promtool", "",
+		"path to promtool, to check the Prometheus config before changing it (empty to disable)")
+	flagHistoryDir = flag.String("history-dir", "/etc/cmos/config-history",
+		"directory to keep the revisions of the managed config in, so that changes can be rolled back (empty to disable)")
+	flagHistorySize = flag.Int("history-size", 50, "how many revisions of the managed config to keep")
+	flagSecretsDir  = flag.String("secrets-dir", "/etc/cmos/secrets",
+		"private directory to keep the passwords of managed clusters in, rather than in the config (empty to disable)")
+
+	flagDiscoveryConnectTimeout = flag.Duration("discovery-connect-timeout", couchbase.DefaultConnectTimeout,
+		"how long to wait to connect to a Couchbase Server node")
+	flagDiscoveryRequestTimeout = flag.Duration("discovery-request-timeout", couchbase.DefaultRequestTimeout,
+		"how long to wait for each attempt at a request to Couchbase Server")
+	flagDiscoveryAttempts = flag.Int("discovery-attempts", couchbase.DefaultMaxAttempts,
+		"how many times to attempt a request to Couchbase Server that fails in a way that may be temporary")
+	flagDiscoveryRetryBackoff = flag.Duration("discovery-retry-backoff", couchbase.DefaultRetryBackoff,
+		"how long to wait before retrying a request to Couchbase Server, doubled for each retry after the first")
 )
 
 func main() {
@@ -55,7 +86,30 @@ func main() {
 		}
 	}
 
-	server, err := api.NewServer(baseLogger, *flagHTTPPathPrefix, !*flagDevelopment)
+	targetMode := api.TargetMode(*flagTargetMode)
+	switch targetMode {
+	case api.TargetModePrometheusConfig, api.TargetModeHTTPSD, api.TargetModeFileSD:
+	default:
+		logger.Fatalw("Invalid target mode", "mode", *flagTargetMode)
+	}
+
+	server, err := api.NewServer(baseLogger, *flagHTTPPathPrefix, !*flagDevelopment, api.Options{
+		ReconcileInterval: *flagReconcileInterval,
+		TargetMode:        targetMode,
+		ManagedConfigPath: *flagManagedConfigFile,
+		FileSDDir:         *flagFileSDDir,
+		PrometheusURL:     *flagPrometheusURL,
+		PromtoolPath:      *flagPromtool,
+		HistoryDir:        *flagHistoryDir,
+		HistorySize:       *flagHistorySize,
+		SecretsDir:        *flagSecretsDir,
+		Discovery: couchbase.ClientOptions{
+			ConnectTimeout: *flagDiscoveryConnectTimeout,
+			RequestTimeout: *flagDiscoveryRequestTimeout,
+			MaxAttempts:    *flagDiscoveryAttempts,
+			RetryBackoff:   *flagDiscoveryRetryBackoff,
+		},
+	})
 	if err != nil {
 		logger.Fatalw("Failed to create API server", "err", err)
 	}
@@ -21,6 +21,19 @@ export CMOS_CFG_DEVELOPMENT=${CMOS_CFG_DEVELOPMENT:-false}
 export CMOS_CFG_HTTP_PATH_PREFIX=${CMOS_CFG_HTTP_PATH_PREFIX:-}
 export CMOS_CFG_HTTP_HOST=${CMOS_CFG_HTTP_HOST:-0.0.0.0}
 export CMOS_CFG_HTTP_PORT=${CMOS_CFG_HTTP_PORT:-7194}
+export CMOS_CFG_RECONCILE_INTERVAL=${CMOS_CFG_RECONCILE_INTERVAL:-5m}
+export CMOS_CFG_TARGET_MODE=${CMOS_CFG_TARGET_MODE:-prometheus-config}
+export CMOS_CFG_MANAGED_CONFIG_FILE=${CMOS_CFG_MANAGED_CONFIG_FILE:-/etc/cmos/managed-clusters.yml}
+export CMOS_CFG_FILE_SD_DIR=${CMOS_CFG_FILE_SD_DIR:-/etc/prometheus/couchbase/custom}
+export CMOS_CFG_PROMETHEUS_URL=${CMOS_CFG_PROMETHEUS_URL-http://localhost:9090}
+export CMOS_CFG_PROMTOOL=${CMOS_CFG_PROMTOOL:-}
+export CMOS_CFG_HISTORY_DIR=${CMOS_CFG_HISTORY_DIR-/etc/cmos/config-history}
+export CMOS_CFG_HISTORY_SIZE=${CMOS_CFG_HISTORY_SIZE:-50}
+export CMOS_CFG_SECRETS_DIR=${CMOS_CFG_SECRETS_DIR-/etc/cmos/secrets}
+export CMOS_CFG_DISCOVERY_CONNECT_TIMEOUT=${CMOS_CFG_DISCOVERY_CONNECT_TIMEOUT:-5s}
+export CMOS_CFG_DISCOVERY_REQUEST_TIMEOUT=${CMOS_CFG_DISCOVERY_REQUEST_TIMEOUT:-15s}
+export CMOS_CFG_DISCOVERY_ATTEMPTS=${CMOS_CFG_DISCOVERY_ATTEMPTS:-3}
+export CMOS_CFG_DISCOVERY_RETRY_BACKOFF=${CMOS_CFG_DISCOVERY_RETRY_BACKOFF:-500ms}
 
 # Re-export to make sure we pick it up
 export PROMETHEUS_CONFIG_FILE=${PROMETHEUS_CONFIG_FILE:-/etc/prometheus/config.yml}
@@ -40,6 +53,19 @@ else
             -http-path-prefix "${CMOS_CFG_HTTP_PATH_PREFIX}" \
             -http-host "${CMOS_CFG_HTTP_HOST}" \
             -http-port "${CMOS_CFG_HTTP_PORT}" \
+            -reconcile-interval "${CMOS_CFG_RECONCILE_INTERVAL}" \
+            -target-mode "${CMOS_CFG_TARGET_MODE}" \
+            -managed-config-file "${CMOS_CFG_MANAGED_CONFIG_FILE}" \
+            -file-sd-dir "${CMOS_CFG_FILE_SD_DIR}" \
+            -prometheus-url "${CMOS_CFG_PROMETHEUS_URL}" \
+            -promtool "${CMOS_CFG_PROMTOOL}" \
+            -history-dir "${CMOS_CFG_HISTORY_DIR}" \
+            -history-size "${CMOS_CFG_HISTORY_SIZE}" \
+            -secrets-dir "${CMOS_CFG_SECRETS_DIR}" \
+            -discovery-connect-timeout "${CMOS_CFG_DISCOVERY_CONNECT_TIMEOUT}" \
+            -discovery-request-timeout "${CMOS_CFG_DISCOVERY_REQUEST_TIMEOUT}" \
+            -discovery-attempts "${CMOS_CFG_DISCOVERY_ATTEMPTS}" \
+            -discovery-retry-backoff "${CMOS_CFG_DISCOVERY_RETRY_BACKOFF}" \
             ${dev_arg}
       else
           echo "ERROR: No executable to run: CMOS_CFG_BIN=${CMOS_CFG_BIN}"
@@ -0,0 +1,217 @@
+// Copyright 2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	v1 "github.com/couchbaselabs/observability/config-svc/pkg/api/v1"
+	"github.com/couchbaselabs/observability/config-svc/pkg/prometheus"
+	"github.com/labstack/echo/v4"
+	"gopkg.in/yaml.v3"
+)
+
+// configFile is a managed config file opened for reading and then replacing. Until it is closed, no other request in
+// this process, nor any other process honouring the lock, can open it.
+type configFile struct {
+	path string
+	perm os.FileMode
+	// contents is the file as it was when opened.
+	contents []byte
+	// written is what was last written to the file, if anything.
+	written []byte
+
+	lockFile *os.File
+	unlock   func()
+}
+
+// openManagedConfig locks and parses the file the managed scrape configs are kept in: the Prometheus configuration, or
+// our own file in the service discovery modes. The caller must close it to release the lock.
+func (s *Server) openManagedConfig() (*configFile, *prometheus.Configuration, error) {
+	cfgPath := os.Getenv("PROMETHEUS_CONFIG_FILE")
+	if cfgPath == "" {
+		cfgPath = defaultPrometheusConfigPath
+	}
+	if s.opts.TargetMode == TargetModeHTTPSD || s.opts.TargetMode == TargetModeFileSD {
+		cfgPath = s.opts.ManagedConfigPath
+	}
+
+	s.configMu.Lock()
+	cfgFile, err := lockConfigFile(cfgPath)
+	if err != nil {
+		s.configMu.Unlock()
+		return nil, nil, err
+	}
+	cfgFile.unlock = s.configMu.Unlock
+
+	if s.opts.TargetMode == TargetModeHTTPSD || s.opts.TargetMode == TargetModeFileSD {
+		if err := createManagedConfigIfMissing(cfgPath); err != nil {
+			cfgFile.Close()
+			return nil, nil, err
+		}
+	}
+
+	info, err := os.Stat(cfgPath)
+	if err != nil {
+		cfgFile.Close()
+		return nil, nil, fmt.Errorf("failed to open config %s: %w", cfgPath, err)
+	}
+	cfgFile.perm = info.Mode().Perm()
+	cfgFile.contents, err = os.ReadFile(cfgPath)
+	if err != nil {
+		cfgFile.Close()
+		return nil, nil, fmt.Errorf("failed to read config %s: %w", cfgPath, err)
+	}
+	var cfg prometheus.Configuration
+	if err := yaml.Unmarshal(cfgFile.contents, &cfg); err != nil {
+		cfgFile.Close()
+		return nil, nil, fmt.Errorf("failed to parse config %s: %w", cfgPath, err)
+	}
+	return cfgFile, &cfg, nil
+}
+
+// lockConfigFile takes an advisory lock on a config file. The file itself is replaced on every write, so the lock is
+// held on a separate file next to it, which stays put.
+func lockConfigFile(cfgPath string) (*configFile, error) {
+	lockPath := filepath.Join(filepath.Dir(cfgPath), "."+filepath.Base(cfgPath)+".lock")
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create config directory: %w", err)
+	}
+	lockFile, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config lock: %w", err)
+	}
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		lockFile.Close()
+		return nil, fmt.Errorf("failed to lock config: %w", err)
+	}
+	return &configFile{path: cfgPath, lockFile: lockFile}, nil
+}
+
+// Name returns the path of the config file.
+func (f *configFile) Name() string {
+	return f.path
+}
+
+// Close releases the lock. It is safe to call more than once.
+func (f *configFile) Close() error {
+	if f.lockFile == nil {
+		return nil
+	}
+	// Closing the file releases the flock
+	err := f.lockFile.Close()
+	f.lockFile = nil
+	if f.unlock != nil {
+		f.unlock()
+	}
+	return err
+}
+
+// write replaces the config file with contents, keeping its permissions.
+func (f *configFile) write(contents []byte) error {
+	err := writeFileAtomically(f.path, contents, f.perm)
+	if errors.Is(err, syscall.EBUSY) {
+		// A file bind mounted into a container cannot be replaced, only written to
+		err = os.WriteFile(f.path, contents, f.perm)
+	}
+	if err != nil {
+		return err
+	}
+	f.written = contents
+	return nil
+}
+
+// ETag identifies the current version of the config file, so that clients can make sure nobody else has changed it
+// between them reading and changing it.
+func (f *configFile) ETag() string {
+	contents := f.contents
+	if f.written != nil {
+		contents = f.written
+	}
+	return `"` + contentHash(contents) + `"`
+}
+
+// contentHash returns a short hash of a config, enough to tell its versions apart.
+func contentHash(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return fmt.Sprintf("%x", sum[:8])
+}
+
+// checkIfMatch fails with 412 Precondition Failed unless the config file is still at one of the versions in an
+// If-Match header. No header means the client does not care.
+func checkIfMatch(cfgFile *configFile, ifMatch *v1.IfMatch) error {
+	if ifMatch == nil {
+		return nil
+	}
+	etag := cfgFile.ETag()
+	for _, candidate := range strings.Split(string(*ifMatch), ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return nil
+		}
+	}
+	return echo.NewHTTPError(http.StatusPreconditionFailed, "the managed clusters have been changed by someone else "+
+		"since they were read")
+}
+
+// createManagedConfigIfMissing creates an empty managed config file. It only holds clusters' credentials, so only we
+// can read it.
+func createManagedConfigIfMissing(cfgPath string) error {
+	if _, err := os.Stat(cfgPath); !os.IsNotExist(err) {
+		return nil
+	}
+	if err := writeFileAtomically(cfgPath, []byte("scrape_configs: []\n"), 0o600); err != nil {
+		return fmt.Errorf("failed to create managed config: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomically writes contents to a temporary file next to path and renames it into place, so that readers see
+// either the old contents or the new, never a partial write, even if we crash part way.
+func writeFileAtomically(path string, contents []byte, perm os.FileMode) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmpFile.Write(contents); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmpFile.Chmod(perm); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to set permissions of %s: %w", path, err)
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to sync %s: %w", path, err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", path, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
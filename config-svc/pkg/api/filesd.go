@@ -0,0 +1,78 @@
+// Copyright 2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/couchbaselabs/observability/config-svc/pkg/prometheus"
+)
+
+// targetFilePrefix marks the file service discovery files we write, so that we never remove anyone else's.
+const targetFilePrefix = "cmos-"
+
+// writeCurrentTargetFiles brings the file service discovery files up to date with the targets last fetched from the
+// clusters, in file SD mode. The config is kept locked meanwhile, so that the files match it even if a cluster is being
+// added or removed.
+func (s *Server) writeCurrentTargetFiles() error {
+	if s.opts.TargetMode != TargetModeFileSD {
+		return nil
+	}
+	cfgFile, cfg, err := s.openManagedConfig()
+	if err != nil {
+		return err
+	}
+	defer cfgFile.Close()
+	return s.writeTargetFiles(cfg)
+}
+
+// writeTargetFiles writes a file service discovery file for each managed cluster, and removes those of clusters that
+// are no longer managed. Prometheus watches the directory, so no reload is needed.
+func (s *Server) writeTargetFiles(cfg *prometheus.Configuration) error {
+	if err := os.MkdirAll(s.opts.FileSDDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create target directory: %w", err)
+	}
+
+	wanted := make(map[string]bool, len(cfg.ScrapeConfigs))
+	for _, sc := range cfg.ScrapeConfigs {
+		targetPath := filepath.Join(s.opts.FileSDDir, targetFilePrefix+sc.JobName+".json")
+		wanted[targetPath] = true
+
+		contents, err := json.MarshalIndent(s.targetGroupsForScrapeConfig(sc), "", "    ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal targets of %s: %w", sc.JobName, err)
+		}
+		if err := writeFileAtomically(targetPath, append(contents, '\n'), 0o644); err != nil {
+			return err
+		}
+	}
+
+	existing, err := filepath.Glob(filepath.Join(s.opts.FileSDDir, targetFilePrefix+"*.json"))
+	if err != nil {
+		return fmt.Errorf("failed to list target files: %w", err)
+	}
+	for _, targetPath := range existing {
+		if wanted[targetPath] {
+			continue
+		}
+		if err := os.Remove(targetPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove target file: %w", err)
+		}
+	}
+	return nil
+}
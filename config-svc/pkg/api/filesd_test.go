@@ -0,0 +1,98 @@
+// Copyright 2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	v1 "github.com/couchbaselabs/observability/config-svc/pkg/api/v1"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestWriteTargetFiles(t *testing.T) {
+	promCfgPath := setupForSGWTest(t)
+	testDir := t.TempDir()
+	managedCfgPath := filepath.Join(testDir, "managed.yml")
+	fileSDDir := filepath.Join(testDir, "custom")
+	require.NoError(t, os.WriteFile(managedCfgPath, []byte("scrape_configs:\n"+managedPromConfig), 0o600))
+	require.NoError(t, os.MkdirAll(fileSDDir, 0o755))
+	// Targets added by hand must be left alone
+	require.NoError(t, os.WriteFile(filepath.Join(fileSDDir, "targets.json"), []byte("[]"), 0o644))
+
+	h := &Server{
+		baseLogger: zap.NewNop(),
+		logger:     zap.NewNop(),
+		echo:       echo.New(),
+		production: true,
+		opts: Options{
+			TargetMode:        TargetModeFileSD,
+			ManagedConfigPath: managedCfgPath,
+			FileSDDir:         fileSDDir,
+		},
+	}
+
+	require.NoError(t, h.reconcileAll())
+
+	result, err := os.ReadFile(filepath.Join(fileSDDir, "cmos-couchbase-server-managed-1.json"))
+	require.NoError(t, err)
+	require.JSONEq(t, `[
+		{
+			"targets": ["test1:18091", "test2:18091"],
+			"labels": {
+				"job": "couchbase-server-managed-1",
+				"__metrics_path__": "/metrics",
+				"__scheme__": "https",
+				"cluster_name": "Test Cluster"
+			}
+		}
+	]`, string(result))
+
+	result, err = os.ReadFile(filepath.Join(fileSDDir, "cmos-sync-gateway-managed-2.json"))
+	require.NoError(t, err)
+	require.JSONEq(t, `[
+		{
+			"targets": ["test:4986"],
+			"labels": {
+				"job": "sync-gateway-managed-2",
+				"__metrics_path__": "/_metrics",
+				"__scheme__": "http"
+			}
+		}
+	]`, string(result))
+
+	t.Run("Delete", func(t *testing.T) {
+		ctx := h.echo.NewContext(httptest.NewRequest(http.MethodDelete, "/api/v1/clusters/couchbase-server-managed-1",
+			nil), httptest.NewRecorder())
+		require.NoError(t, h.DeleteClustersId(ctx, "couchbase-server-managed-1", v1.DeleteClustersIdParams{}))
+
+		files, err := filepath.Glob(filepath.Join(fileSDDir, "*"))
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{
+			filepath.Join(fileSDDir, "cmos-sync-gateway-managed-2.json"),
+			filepath.Join(fileSDDir, "targets.json"),
+		}, files)
+
+		// The Prometheus config is never touched
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Equal(t, basePromConfig, string(result))
+	})
+}
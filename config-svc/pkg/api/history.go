@@ -0,0 +1,279 @@
+// Copyright 2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	v1 "github.com/couchbaselabs/observability/config-svc/pkg/api/v1"
+	"github.com/couchbaselabs/observability/config-svc/pkg/prometheus"
+	"github.com/labstack/echo/v4"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// defaultHistorySize is how many revisions are kept if Options.HistorySize is not set.
+	defaultHistorySize = 50
+	// historyIndexFile lists the revisions in the history directory, oldest first.
+	historyIndexFile = "index.json"
+	// externalChangeUser is recorded as the user of changes made to the config by something other than this service.
+	externalChangeUser = "unknown"
+	// headerRemoteUser is set by the proxy in front of us to the user it authenticated the client as.
+	headerRemoteUser = "X-Remote-User"
+)
+
+// configChange describes a change to the managed config, for its history.
+type configChange struct {
+	operation string
+	user      string
+}
+
+// changeBy describes a change requested through the API. There is no authentication of our own, so the user is the
+// one a proxy on this host (nginx in the microlith) authenticated the client as, or failing that their address. The
+// credentials the client sent are never trusted, as nothing checks them.
+func changeBy(ctx echo.Context, format string, args ...interface{}) configChange {
+	user := ctx.RealIP()
+	if username := ctx.Request().Header.Get(headerRemoteUser); username != "" && fromLocalProxy(ctx.Request()) {
+		user = username
+	}
+	return configChange{operation: fmt.Sprintf(format, args...), user: user}
+}
+
+// fromLocalProxy returns whether the request came directly from this host, and so its headers were set by our proxy
+// rather than the client.
+func fromLocalProxy(req *http.Request) bool {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (s *Server) GetHistory(ctx echo.Context) error {
+	revisions, err := s.readHistory()
+	if err != nil {
+		return err
+	}
+	// Newest first, as the latest changes are the ones most likely to be undone
+	newestFirst := make([]v1.ConfigRevision, 0, len(revisions))
+	for i := len(revisions) - 1; i >= 0; i-- {
+		newestFirst = append(newestFirst, revisions[i])
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{
+		"revisions": newestFirst,
+	})
+}
+
+func (s *Server) GetHistoryDiff(ctx echo.Context, params v1.GetHistoryDiffParams) error {
+	from, err := s.readRevision(params.From)
+	if err != nil {
+		return err
+	}
+	to, err := s.readRevision(params.To)
+	if err != nil {
+		return err
+	}
+	diff, err := diffConfigs(from, to, fmt.Sprintf("revision %d", params.From), fmt.Sprintf("revision %d", params.To))
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, v1.ConfigDiff{
+		Diff: diff,
+	})
+}
+
+func (s *Server) PostHistoryRevisionRollback(ctx echo.Context, revision int,
+	params v1.PostHistoryRevisionRollbackParams) error {
+	contents, err := s.readRevision(revision)
+	if err != nil {
+		return err
+	}
+	var cfg prometheus.Configuration
+	if err := yaml.Unmarshal(contents, &cfg); err != nil {
+		return fmt.Errorf("failed to parse revision %d: %w", revision, err)
+	}
+
+	cfgFile, _, err := s.openManagedConfig()
+	if err != nil {
+		return err
+	}
+	defer cfgFile.Close()
+	if err := checkIfMatch(cfgFile, params.IfMatch); err != nil {
+		return err
+	}
+	if err := checkSecretFiles(&cfg); err != nil {
+		return err
+	}
+	// A revision from before password files were used still has its passwords inline
+	passwords := s.externalisePasswords(&cfg)
+	if len(passwords) > 0 {
+		if contents, err = yaml.Marshal(&cfg); err != nil {
+			return fmt.Errorf("failed to marshal config: %w", err)
+		}
+	}
+
+	// Otherwise write the revision as it was rather than re-marshalling it, so that rolling back changes nothing else
+	if err := s.writeManagedContents(cfgFile, contents, &cfg, passwords, changeBy(ctx, "roll back to revision %d",
+		revision)); err != nil {
+		return err
+	}
+	return respondOK(ctx, cfgFile)
+}
+
+// readHistory returns the revisions in the history, oldest first.
+func (s *Server) readHistory() ([]v1.ConfigRevision, error) {
+	if s.opts.HistoryDir == "" {
+		return nil, nil
+	}
+	contents, err := os.ReadFile(filepath.Join(s.opts.HistoryDir, historyIndexFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config history: %w", err)
+	}
+	var revisions []v1.ConfigRevision
+	if err := json.Unmarshal(contents, &revisions); err != nil {
+		return nil, fmt.Errorf("failed to parse config history: %w", err)
+	}
+	return revisions, nil
+}
+
+// readRevision returns the config of a revision in the history.
+func (s *Server) readRevision(id int) ([]byte, error) {
+	revisions, err := s.readHistory()
+	if err != nil {
+		return nil, err
+	}
+	for _, revision := range revisions {
+		if revision.Id != id {
+			continue
+		}
+		contents, err := os.ReadFile(s.revisionPath(id))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read revision %d: %w", id, err)
+		}
+		return contents, nil
+	}
+	return nil, echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("no revision %d in the config history", id))
+}
+
+func (s *Server) revisionPath(id int) string {
+	return filepath.Join(s.opts.HistoryDir, fmt.Sprintf("%d.yml", id))
+}
+
+// withoutInlinePasswords returns a config written before password files were used, or edited by hand, with its
+// passwords replaced by the files they are moved to whenever the config is written, see externalisePasswords. This
+// keeps them out of the history as long as there is a secrets directory, and is a no-op without one.
+func (s *Server) withoutInlinePasswords(contents []byte) ([]byte, error) {
+	var cfg prometheus.Configuration
+	if err := yaml.Unmarshal(contents, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	if len(s.externalisePasswords(&cfg)) == 0 {
+		return contents, nil
+	}
+	externalised, err := yaml.Marshal(&cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	return externalised, nil
+}
+
+// recordRevision adds what was just written to the config file to the history, dropping the oldest revisions beyond
+// the history size. It must be called with the config file still open, so that revisions are recorded in order.
+func (s *Server) recordRevision(cfgFile *configFile, change configChange) error {
+	if s.opts.HistoryDir == "" || cfgFile.written == nil {
+		return nil
+	}
+	// The history holds credentials, just like the config
+	if err := os.MkdirAll(s.opts.HistoryDir, 0o700); err != nil {
+		return fmt.Errorf("failed to create config history directory: %w", err)
+	}
+	revisions, err := s.readHistory()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	addRevision := func(contents []byte, change configChange) error {
+		id := 1
+		if len(revisions) > 0 {
+			id = revisions[len(revisions)-1].Id + 1
+		}
+		if err := writeFileAtomically(s.revisionPath(id), contents, 0o600); err != nil {
+			return err
+		}
+		revisions = append(revisions, v1.ConfigRevision{
+			Id:        id,
+			Timestamp: now,
+			Operation: change.operation,
+			User:      change.user,
+			Hash:      contentHash(contents),
+		})
+		return nil
+	}
+	// Keep whatever the config was before this change if we do not have it already, e.g. before our first change or
+	// after the file was edited by hand, so that it can be rolled back to
+	if len(revisions) == 0 || revisions[len(revisions)-1].Hash != contentHash(cfgFile.contents) {
+		operation := "changed outside the configuration service"
+		if len(revisions) == 0 {
+			operation = "before the first recorded change"
+		}
+		previous, err := s.withoutInlinePasswords(cfgFile.contents)
+		if err != nil {
+			return err
+		}
+		if err := addRevision(previous, configChange{operation: operation, user: externalChangeUser}); err != nil {
+			return err
+		}
+	}
+	if err := addRevision(cfgFile.written, change); err != nil {
+		return err
+	}
+
+	size := s.opts.HistorySize
+	if size <= 0 {
+		size = defaultHistorySize
+	}
+	var dropped []v1.ConfigRevision
+	if len(revisions) > size {
+		dropped = revisions[:len(revisions)-size]
+		revisions = revisions[len(revisions)-size:]
+	}
+
+	index, err := json.MarshalIndent(revisions, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config history: %w", err)
+	}
+	if err := writeFileAtomically(filepath.Join(s.opts.HistoryDir, historyIndexFile), append(index, '\n'),
+		0o600); err != nil {
+		return err
+	}
+	// Only remove revisions once the index no longer refers to them
+	for _, revision := range dropped {
+		if err := os.Remove(s.revisionPath(revision.Id)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove revision %d: %w", revision.Id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	v1 "github.com/couchbaselabs/observability/config-svc/pkg/api/v1"
+	"github.com/couchbaselabs/observability/config-svc/pkg/prometheus"
+	"github.com/pmezard/go-difflib/difflib"
+	"gopkg.in/yaml.v3"
+)
+
+// passwordLine matches the YAML lines holding a password, so that previews can be shared for review.
+var passwordLine = regexp.MustCompile(`(?m)^(\s*password:).*$`)
+
+func redactPasswords(contents string) string {
+	return passwordLine.ReplaceAllString(contents, "$1 <redacted>")
+}
+
+// splitLines splits contents into lines keeping their line endings, as the diff expects. Unlike difflib.SplitLines, it
+// does not add an empty line to files that end in a newline.
+func splitLines(contents string) []string {
+	lines := strings.SplitAfter(contents, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// previewChange describes what writing cfg over the file opened by openManagedConfig would change, without writing it.
+func previewChange(cfgFile *configFile, cfg *prometheus.Configuration,
+	sc *prometheus.ScrapeConfig) (*v1.ConfigPreview, error) {
+	scYaml, err := yaml.Marshal(sc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal scrape config: %w", err)
+	}
+	updated, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	diff, err := diffConfigs(cfgFile.contents, updated, cfgFile.Name(), cfgFile.Name())
+	if err != nil {
+		return nil, err
+	}
+	return &v1.ConfigPreview{
+		ScrapeConfig: redactPasswords(string(scYaml)),
+		Diff:         diff,
+	}, nil
+}
+
+// diffConfigs returns a unified diff between two configs, with their passwords redacted.
+func diffConfigs(from, to []byte, fromName, toName string) (string, error) {
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(redactPasswords(string(from))),
+		B:        splitLines(redactPasswords(string(to))),
+		FromFile: fromName,
+		ToFile:   toName,
+		Context:  3,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to diff config: %w", err)
+	}
+	return diff, nil
+}
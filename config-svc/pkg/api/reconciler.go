@@ -0,0 +1,226 @@
+// Copyright 2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "github.com/couchbaselabs/observability/config-svc/pkg/api/v1"
+	"github.com/couchbaselabs/observability/config-svc/pkg/prometheus"
+	"github.com/labstack/echo/v4"
+)
+
+// reconcilerUser is recorded in the config history as the user of the changes made by the reconciler.
+const reconcilerUser = "reconciler"
+
+var (
+	errNoCredentials = errors.New("no credentials are stored for this cluster")
+	// errTargetsUnknown is returned when a cluster using service discovery itself could not be asked for its targets.
+	errTargetsUnknown = errors.New("failed to get targets of cluster")
+)
+
+// runReconciler periodically re-discovers the nodes of every managed Couchbase Server cluster, so that nodes added or
+// removed by a rebalance are picked up without having to re-add the cluster. The clusters are checked straight away
+// too, as the targets of those that use service discovery themselves are only known once they have been.
+func (s *Server) runReconciler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.reconcileAll(); err != nil {
+			s.logger.Sugar().Warnw("Failed to reconcile managed clusters", "err", err)
+		}
+		<-ticker.C
+	}
+}
+
+// reconcileAll checks each managed Couchbase Server cluster, and rewrites the targets of those whose topology has
+// changed.
+func (s *Server) reconcileAll() error {
+	cfgFile, cfg, err := s.openManagedConfig()
+	if err != nil {
+		return err
+	}
+	cfgFile.Close()
+
+	changed := make(map[string]*prometheus.ScrapeConfig)
+	for _, sc := range cfg.ScrapeConfigs {
+		managed := managedClusterFromScrapeConfig(sc)
+		if managed.Kind != v1.ManagedClusterKindCouchbaseServer {
+			continue
+		}
+		reconciled, err := s.reconcileScrapeConfig(context.Background(), sc)
+		s.recordReconcile(managed.Id, err)
+		if err != nil {
+			s.logger.Sugar().Warnw("Failed to reconcile cluster", "id", managed.Id, "err", err)
+			continue
+		}
+		if !reflect.DeepEqual(reconciled.StaticConfigs, sc.StaticConfigs) ||
+			!reflect.DeepEqual(httpSDURLs(reconciled), httpSDURLs(sc)) ||
+			!reflect.DeepEqual(reconciled.RelabelConfigs, sc.RelabelConfigs) ||
+			!reflect.DeepEqual(exporterStaticConfigs(reconciled), exporterStaticConfigs(sc)) {
+			s.logger.Sugar().Infow("Cluster topology changed", "id", managed.Id)
+			changed[managed.Id] = reconciled
+		}
+	}
+	if len(changed) == 0 {
+		// Clusters using service discovery themselves can change without their scrape config changing
+		return s.writeCurrentTargetFiles()
+	}
+
+	// Discovery can take a while, so re-read the config and only replace the targets, to keep any changes made to it
+	// in the meantime. A cluster that has been upgraded to 7.1 can switch over to service discovery, too.
+	cfgFile, cfg, err = s.openManagedConfig()
+	if err != nil {
+		return err
+	}
+	defer cfgFile.Close()
+	ids := make([]string, 0, len(changed))
+	for id, reconciled := range changed {
+		if idx := findManagedScrapeConfig(cfg, id); idx != -1 {
+			cfg.ScrapeConfigs[idx].StaticConfigs = reconciled.StaticConfigs
+			cfg.ScrapeConfigs[idx].HTTPSDConfigs = reconciled.HTTPSDConfigs
+			cfg.ScrapeConfigs[idx].RelabelConfigs = reconciled.RelabelConfigs
+			cfg.ScrapeConfigs[idx].Exporter = reconciled.Exporter
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return s.writeManagedConfig(cfgFile, cfg, nil, configChange{
+		operation: "update the nodes of " + strings.Join(ids, ", "),
+		user:      reconcilerUser,
+	})
+}
+
+// reconcileScrapeConfig fetches the current nodes of the cluster behind a managed scrape config, and returns the scrape
+// config it should now have.
+func (s *Server) reconcileScrapeConfig(ctx context.Context, sc *prometheus.ScrapeConfig) (*prometheus.ScrapeConfig,
+	error) {
+	// Clusters authenticated to with a client certificate have no username
+	username, password, err := sc.HTTPClientConfig.BasicAuth.Credentials()
+	if err != nil {
+		return nil, err
+	}
+	if username == "" && (sc.HTTPClientConfig.TLSConfig == nil || sc.HTTPClientConfig.TLSConfig.CertFile == "") {
+		return nil, errNoCredentials
+	}
+	tlsConfig, err := sc.HTTPClientConfig.TLSConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	conn := &clusterConnection{
+		client:   s.couchbaseClient(tlsConfig),
+		scheme:   "http",
+		network:  scrapeConfigNetwork(sc),
+		username: username,
+		password: password,
+	}
+	if sc.Scheme == "https" {
+		conn.scheme, conn.useTLS = "https", true
+	}
+
+	var metricsConfig v1.MetricsConfig
+	if metricsPort, ok := sc.Annotations[metricsPortAnnotation]; ok {
+		port, err := strconv.ParseFloat(metricsPort, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metrics port %q: %w", metricsPort, err)
+		}
+		port32 := float32(port)
+		metricsConfig.MetricsPort = &port32
+	}
+	if sc.Exporter != nil {
+		exporterUsername, exporterPassword, err := sc.Exporter.HTTPClientConfig.BasicAuth.Credentials()
+		if err != nil {
+			return nil, err
+		}
+		metricsConfig.ExporterUsername = &exporterUsername
+		metricsConfig.ExporterPassword = &exporterPassword
+	}
+
+	managed := managedClusterFromScrapeConfig(sc)
+	reconciled, _, err := s.createManagedScrapeConfig(ctx, conn, append(connectionStringSeeds(ctx, sc),
+		managed.Targets...), sc.ID, managed.Name, &metricsConfig)
+	if err != nil {
+		return nil, err
+	}
+	setJobName(reconciled, sc.JobName)
+	setTLSConfig(reconciled, sc.HTTPClientConfig.TLSConfig)
+	return reconciled, nil
+}
+
+// httpSDURLs returns where a scrape config discovers its targets from. The rest of its service discovery settings are
+// left out, as the credentials are kept in a password file once written but are inline in a newly created config.
+func httpSDURLs(sc *prometheus.ScrapeConfig) []string {
+	urls := make([]string, 0, len(sc.HTTPSDConfigs))
+	for _, sdConfig := range sc.HTTPSDConfigs {
+		urls = append(urls, sdConfig.URL)
+	}
+	return urls
+}
+
+// exporterStaticConfigs returns the targets of the exporter job of a scrape config, if it has one.
+func exporterStaticConfigs(sc *prometheus.ScrapeConfig) []prometheus.StaticConfig {
+	if sc.Exporter == nil {
+		return nil
+	}
+	return sc.Exporter.StaticConfigs
+}
+
+func (s *Server) recordReconcile(id string, err error) {
+	s.reconcileMu.Lock()
+	defer s.reconcileMu.Unlock()
+	if s.reconcileStatus == nil {
+		s.reconcileStatus = make(map[string]v1.ReconcileStatus)
+	}
+	now := time.Now()
+	status := s.reconcileStatus[id]
+	status.LastAttempt = now
+	if err != nil {
+		msg := err.Error()
+		// Errors shared with the API carry the status code they are returned with, which means nothing here
+		var httpErr *echo.HTTPError
+		if errors.As(err, &httpErr) {
+			msg = fmt.Sprint(httpErr.Message)
+		}
+		status.LastError = &msg
+	} else {
+		status.LastSuccess = &now
+		status.LastError = nil
+	}
+	s.reconcileStatus[id] = status
+}
+
+func (s *Server) forgetReconcileStatus(id string) {
+	s.reconcileMu.Lock()
+	defer s.reconcileMu.Unlock()
+	delete(s.reconcileStatus, id)
+}
+
+// getReconcileStatus returns the outcome of the last check of the given cluster, or nil if it has not been checked.
+func (s *Server) getReconcileStatus(id string) *v1.ReconcileStatus {
+	s.reconcileMu.Lock()
+	defer s.reconcileMu.Unlock()
+	status, ok := s.reconcileStatus[id]
+	if !ok {
+		return nil
+	}
+	return &status
+}
@@ -0,0 +1,261 @@
+// Copyright 2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/tools-common/cbrest"
+	"github.com/couchbase/tools-common/cbvalue"
+	v1 "github.com/couchbaselabs/observability/config-svc/pkg/api/v1"
+	"github.com/couchbaselabs/observability/config-svc/pkg/couchbase"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestReconcileAll(t *testing.T) {
+	nodes := []couchbase.Node{
+		{
+			Hostname: "test1:8091",
+			Version:  cbvalue.Version7_0_0,
+		},
+	}
+	promCfgPath, testCluster := setupForTest(t, cbrest.TestClusterOptions{
+		UUID: testClusterUUID,
+		Handlers: map[string]http.HandlerFunc{
+			"GET:/pools/default": func(w http.ResponseWriter, r *http.Request) {
+				if username, _, _ := r.BasicAuth(); username != "Administrator" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+				_ = json.NewEncoder(w).Encode(&couchbase.PoolsDefault{
+					ClusterName: "Test Cluster",
+					Nodes:       nodes,
+				})
+			},
+		},
+	})
+	defer testCluster.Close()
+
+	const managedConfig = `    # CMOS managed: 6d3e2b8a seed=localhost:%d
+    - job_name: couchbase-server-managed-prod
+      metrics_path: /metrics
+      basic_auth:
+        username: %s
+        password: asdasd
+      static_configs:
+%s`
+	// nodeGroups returns the target group of each node, as they are labelled with the node's details
+	nodeGroups := func(targets ...string) string {
+		var groups string
+		for _, target := range targets {
+			groups += fmt.Sprintf(`        - targets:
+            - %s
+          labels:
+            cluster_name: Prod
+            cluster_uuid: 6d3e2b8a
+`, target)
+		}
+		return groups
+	}
+
+	h := &Server{
+		baseLogger: zap.NewNop(),
+		logger:     zap.NewNop(),
+		echo:       echo.New(),
+		production: true,
+		opts: Options{
+			Discovery: couchbase.ClientOptions{MaxAttempts: 1},
+		},
+	}
+
+	t.Run("Unchanged", func(t *testing.T) {
+		existing := basePromConfig + fmt.Sprintf(managedConfig, testCluster.Port(), "Administrator", nodeGroups("test1:8091"))
+		require.NoError(t, os.WriteFile(promCfgPath, []byte(existing), 0o666))
+
+		require.NoError(t, h.reconcileAll())
+
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Equal(t, existing, string(result))
+
+		status := h.getReconcileStatus(testClusterUUID)
+		require.NotNil(t, status)
+		require.Nil(t, status.LastError)
+		require.NotNil(t, status.LastSuccess)
+	})
+
+	t.Run("NodeAdded", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(promCfgPath, []byte(basePromConfig+fmt.Sprintf(managedConfig,
+			testCluster.Port(), "Administrator", nodeGroups("test1:8091"))), 0o666))
+		nodes = append(nodes, couchbase.Node{
+			Hostname: "test2:8091",
+			Version:  cbvalue.Version7_0_0,
+		})
+
+		require.NoError(t, h.reconcileAll())
+
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Equal(t, basePromConfig+fmt.Sprintf(managedConfig, testCluster.Port(), "Administrator",
+			nodeGroups("test1:8091", "test2:8091")), string(result))
+	})
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		existing := basePromConfig + fmt.Sprintf(managedConfig, testCluster.Port(), "someone", nodeGroups("test1:8091"))
+		require.NoError(t, os.WriteFile(promCfgPath, []byte(existing), 0o666))
+
+		require.NoError(t, h.reconcileAll())
+
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Equal(t, existing, string(result))
+
+		status := h.getReconcileStatus(testClusterUUID)
+		require.NotNil(t, status)
+		require.NotNil(t, status.LastError)
+		require.Contains(t, *status.LastError, "401")
+	})
+
+	t.Run("SeedDown", func(t *testing.T) {
+		// The cluster was last contacted on a node that is now down, but was added with another one as well
+		existing := basePromConfig + strings.Replace(fmt.Sprintf(managedConfig, testCluster.Port(), "Administrator",
+			nodeGroups("test1:8091", "test2:8091")), "seed=localhost:",
+			"seed=127.0.0.1:1 seeds=127.0.0.1:1%2Clocalhost:", 1)
+		require.NoError(t, os.WriteFile(promCfgPath, []byte(existing), 0o666))
+
+		require.NoError(t, h.reconcileAll())
+
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Equal(t, existing, string(result))
+
+		status := h.getReconcileStatus(testClusterUUID)
+		require.NotNil(t, status)
+		require.Nil(t, status.LastError)
+	})
+
+	t.Run("ConnectionStringResolved", func(t *testing.T) {
+		// None of the addresses the cluster was contacted on is up, but its connection string lists others
+		existing := basePromConfig + strings.Replace(fmt.Sprintf(managedConfig, testCluster.Port(), "Administrator",
+			nodeGroups("test1:8091", "test2:8091")), "seed=localhost:",
+			"connectionString=couchbase:%2F%2F127.0.0.3%2Clocalhost seed=127.0.0.2:", 1)
+		require.NoError(t, os.WriteFile(promCfgPath, []byte(existing), 0o666))
+
+		require.NoError(t, h.reconcileAll())
+
+		status := h.getReconcileStatus(testClusterUUID)
+		require.NotNil(t, status)
+		require.Nil(t, status.LastError)
+	})
+
+	t.Run("ExporterNodeChanged", func(t *testing.T) {
+		const exporterConfig = `    # CMOS managed: 6d3e2b8a part=exporter
+    - job_name: couchbase-server-managed-prod-exporter
+      metrics_path: /metrics
+      basic_auth:
+        username: exporter
+        password: exporter-password
+      static_configs:
+        - targets:
+            - %s
+          labels:
+            cluster_name: Prod
+            cluster_uuid: 6d3e2b8a
+`
+		withNodes := fmt.Sprintf(managedConfig, testCluster.Port(), "Administrator",
+			nodeGroups("test1:8091", "test2:8091"))
+		require.NoError(t, os.WriteFile(promCfgPath, []byte(basePromConfig+withNodes+
+			fmt.Sprintf(exporterConfig, "gone:9091")), 0o666))
+		nodes = append(nodes, couchbase.Node{
+			Hostname: "old:8091",
+			Version:  cbvalue.Version6_6_0,
+		})
+
+		require.NoError(t, h.reconcileAll())
+
+		// The exporter's own credentials are kept
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Equal(t, basePromConfig+withNodes+fmt.Sprintf(exporterConfig, "old:9091"), string(result))
+	})
+}
+
+func TestReconcileAllServiceDiscovery(t *testing.T) {
+	promCfgPath, testCluster := setupForTest(t, cbrest.TestClusterOptions{
+		UUID: testClusterUUID,
+		Handlers: map[string]http.HandlerFunc{
+			"GET:/pools/default": func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				_ = json.NewEncoder(w).Encode(&couchbase.PoolsDefault{
+					ClusterName: "Test Cluster",
+					Nodes: []couchbase.Node{
+						{
+							Hostname: "test1:8091",
+							Version:  cbvalue.Version7_1_0,
+						},
+					},
+				})
+			},
+		},
+	})
+	defer testCluster.Close()
+
+	e := echo.New()
+	h := &Server{
+		baseLogger: zap.NewNop(),
+		logger:     zap.NewNop(),
+		echo:       e,
+		production: true,
+		opts: Options{
+			HistoryDir: filepath.Join(t.TempDir(), "history"),
+			SecretsDir: filepath.Join(t.TempDir(), "secrets"),
+			Discovery:  couchbase.ClientOptions{MaxAttempts: 1},
+		},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/clusters/add", strings.NewReader(fmt.Sprintf(`{
+		"hostname": "localhost",
+		"couchbaseConfig": {
+			"username": "Administrator",
+			"password": "asdasd",
+			"managementPort": %d
+		}
+	}`, testCluster.Port())))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	require.NoError(t, h.PostClustersAdd(e.NewContext(req, httptest.NewRecorder()), v1.PostClustersAddParams{}))
+	added, err := os.ReadFile(promCfgPath)
+	require.NoError(t, err)
+	revisions, err := h.readHistory()
+	require.NoError(t, err)
+
+	// The password kept in a file is the same as the one the cluster was just contacted with, so nothing has changed
+	require.NoError(t, h.reconcileAll())
+
+	result, err := os.ReadFile(promCfgPath)
+	require.NoError(t, err)
+	require.Equal(t, string(added), string(result))
+	afterReconcile, err := h.readHistory()
+	require.NoError(t, err)
+	require.Len(t, afterReconcile, len(revisions))
+}
@@ -15,11 +15,18 @@
 package api
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"io"
+	"net"
 	"net/http"
-	"os"
+	"net/url"
 	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/couchbase/tools-common/cbvalue"
 	"github.com/couchbaselabs/observability/config-svc/pkg/couchbase"
@@ -33,142 +40,290 @@ import (
 const (
 	defaultPrometheusConfigPath = "/etc/prometheus/prometheus.yml"
 	collectInfoPath             = "/collect-information.sh"
+
+	// Annotations kept on managed Couchbase Server scrape configs, so that the cluster can be contacted again later
+	seedAnnotation        = "seed"
+	seedsAnnotation       = "seeds"
+	metricsPortAnnotation = "metricsPort"
+	networkAnnotation     = "network"
+	// connectionStringAnnotation keeps the connection string a cluster was added with, to resolve it again later
+	connectionStringAnnotation = "connectionString"
+
+	// Prefixes of the job names of managed scrape configs, used to tell Couchbase Server and Sync Gateway jobs apart.
+	serverJobPrefix = "couchbase-server-managed-"
+	sgwJobPrefix    = "sync-gateway-managed-"
+	// exporterJobSuffix is appended to the job name of a cluster for the job that scrapes the exporters of its nodes
+	// older than 7.0.
+	exporterJobSuffix = "-exporter"
 )
 
-func (s *Server) PostClustersAdd(ctx echo.Context) error {
-	var data v1.PostClustersAddJSONRequestBody
+// addressLabel holds the address of a target, which relabelling can match to tell the nodes of a cluster apart.
+const addressLabel = "__address__"
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+func (s *Server) GetClusters(ctx echo.Context) error {
+	cfgFile, cfg, err := s.openManagedConfig()
+	if err != nil {
+		return err
+	}
+	cfgFile.Close()
+	ctx.Response().Header().Set("ETag", cfgFile.ETag())
+
+	clusters := make([]v1.ManagedCluster, 0, len(cfg.ScrapeConfigs))
+	for _, sc := range cfg.ScrapeConfigs {
+		cluster := managedClusterFromScrapeConfig(sc)
+		cluster.Reconcile = s.getReconcileStatus(cluster.Id)
+		clusters = append(clusters, cluster)
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]interface{}{
+		"clusters": clusters,
+	})
+}
+
+func (s *Server) PutClustersId(ctx echo.Context, id string, params v1.PutClustersIdParams) error { //nolint:revive
+	var data v1.PutClustersIdJSONRequestBody
 	if err := ctx.Bind(&data); err != nil {
 		return err
 	}
 
-	scheme := "http"
-	useTLS := false
-	if data.CouchbaseConfig.UseTLS != nil && *data.CouchbaseConfig.UseTLS {
-		useTLS = true
-		scheme = "https"
+	// Contacting the cluster can take a while, so the config is not kept locked in the meantime. It is only saved if
+	// nobody else has changed it since.
+	existing, etag, err := s.readManagedCluster(id, params.IfMatch)
+	if err != nil {
+		return err
 	}
-	mgmtPort := 8091
-	if data.CouchbaseConfig.ManagementPort != nil {
-		mgmtPort = int(*data.CouchbaseConfig.ManagementPort)
+	managed := managedClusterFromScrapeConfig(existing)
+	// Keep the port the cluster is contacted on unless another is given, rather than going back to the default
+	if data.CouchbaseConfig.ManagementPort == nil {
+		data.CouchbaseConfig.ManagementPort = managementPort(append(seedAddresses(existing), managed.Targets...))
 	}
-	cluster, err := couchbase.FetchCouchbaseClusterInfo(
-		scheme,
-		data.Hostname,
-		mgmtPort,
-		data.CouchbaseConfig.Username,
-		data.CouchbaseConfig.Password,
-	)
+	// Likewise keep using TLS, which would otherwise be turned off while still contacting the cluster on its secure port
+	if data.CouchbaseConfig.UseTLS == nil {
+		useTLS := existing.Scheme == "https"
+		data.CouchbaseConfig.UseTLS = &useTLS
+	}
+
+	// Re-validate the new settings against the cluster before saving anything, trying the address it was added with
+	// first and then any of its current targets
+	conn, mgmtPort, err := s.newClusterConnection(data.CouchbaseConfig)
 	if err != nil {
-		return fmt.Errorf("unable to get cluster info: %w", err)
+		return err
+	}
+	seeds := withPort(connectionStringSeeds(ctx.Request().Context(), existing), mgmtPort)
+	scrapeConfig, seed, err := s.createManagedScrapeConfig(ctx.Request().Context(), conn,
+		append(append([]string(nil), seeds...), withPort(managed.Targets, mgmtPort)...), existing.ID, managed.Name,
+		data.MetricsConfig)
+	// The reconciler will fetch the targets again, so the cluster is still worth saving
+	if err != nil && !errors.Is(err, errTargetsUnknown) {
+		return err
 	}
 
-	scrapeConfig, err := createScrapeConfigForCluster(
-		cluster,
-		useTLS,
-		data.CouchbaseConfig.Username,
-		data.CouchbaseConfig.Password,
-		data.MetricsConfig,
-	)
+	cfgFile, cfg, err := s.openManagedConfig()
+	if err != nil {
+		return err
+	}
+	defer cfgFile.Close()
+	if cfgFile.ETag() != etag {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "the managed clusters have been changed by someone "+
+			"else while the cluster was being contacted")
+	}
+	idx := findManagedScrapeConfig(cfg, id)
+
+	scrapeConfig.Annotations = clusterAnnotations(seed, seeds, existing.Annotations[connectionStringAnnotation],
+		conn.network, data.MetricsConfig)
+	setJobName(scrapeConfig, existing.JobName)
+	scrapeConfig.MetricsPath = existing.MetricsPath
+	scrapeTLSConfig, tlsFiles, err := s.scrapeTLSConfig(scrapeConfig.JobName, data.CouchbaseConfig)
 	if err != nil {
-		return fmt.Errorf("could not create scrape config: %w", err)
+		return err
 	}
+	setTLSConfig(scrapeConfig, scrapeTLSConfig)
+	cfg.ScrapeConfigs[idx] = scrapeConfig
 
-	cfgPath := os.Getenv("PROMETHEUS_CONFIG_FILE")
-	if cfgPath == "" {
-		cfgPath = defaultPrometheusConfigPath
+	if err := s.writeManagedConfig(cfgFile, cfg, tlsFiles, changeBy(ctx, "update cluster %s", id)); err != nil {
+		return err
 	}
-	cfgFile, err := os.OpenFile(cfgPath, os.O_RDWR, 0)
+
+	return respondOK(ctx, cfgFile)
+}
+
+// readManagedCluster returns the scrape config of a managed Couchbase Server cluster, along with the ETag of the config
+// it was read from.
+func (s *Server) readManagedCluster(id string, ifMatch *v1.IfMatch) (*prometheus.ScrapeConfig, string, error) {
+	cfgFile, cfg, err := s.openManagedConfig()
 	if err != nil {
-		return fmt.Errorf("failed to open Prometheus config: %w", err)
+		return nil, "", err
 	}
 	defer cfgFile.Close()
-	existingConfig, err := io.ReadAll(cfgFile)
+	if err := checkIfMatch(cfgFile, ifMatch); err != nil {
+		return nil, "", err
+	}
+
+	idx := findManagedScrapeConfig(cfg, id)
+	if idx == -1 {
+		return nil, "", echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("no managed cluster with ID %q", id))
+	}
+	if managedClusterFromScrapeConfig(cfg.ScrapeConfigs[idx]).Kind != v1.ManagedClusterKindCouchbaseServer {
+		return nil, "", echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%q is not a Couchbase Server cluster",
+			id))
+	}
+	return cfg.ScrapeConfigs[idx], cfgFile.ETag(), nil
+}
+
+// managementPort returns the port of the first of the addresses a cluster is contacted on, or nil if there is none.
+func managementPort(addresses []string) *float32 {
+	for _, address := range addresses {
+		_, portStr, err := net.SplitHostPort(address)
+		if err != nil {
+			continue
+		}
+		if port, err := strconv.ParseFloat(portStr, 32); err == nil {
+			port32 := float32(port)
+			return &port32
+		}
+	}
+	return nil
+}
+
+func (s *Server) DeleteClustersId(ctx echo.Context, id string, params v1.DeleteClustersIdParams) error { //nolint:revive
+	cfgFile, cfg, err := s.openManagedConfig()
 	if err != nil {
-		return fmt.Errorf("failed to read Prometheus config: %w", err)
+		return err
 	}
-	var cfg prometheus.Configuration
-	if err := yaml.Unmarshal(existingConfig, &cfg); err != nil {
-		return fmt.Errorf("failed to parse Prometheus config: %w", err)
+	defer cfgFile.Close()
+	if err := checkIfMatch(cfgFile, params.IfMatch); err != nil {
+		return err
 	}
 
-	// Job name needs to be unique
-	scrapeConfig.JobName = fmt.Sprintf("couchbase-server-managed-%d", len(cfg.ScrapeConfigs)+1)
+	idx := findManagedScrapeConfig(cfg, id)
+	if idx == -1 {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("no managed cluster with ID %q", id))
+	}
+	// Only the managed scrape configs are touched, the user's own are kept by the Configuration
+	cfg.ScrapeConfigs = append(cfg.ScrapeConfigs[:idx], cfg.ScrapeConfigs[idx+1:]...)
 
-	// Sync Gateway metrics path is metrics
-	scrapeConfig.MetricsPath = "/metrics"
+	if err := s.writeManagedConfig(cfgFile, cfg, nil, changeBy(ctx, "remove cluster %s", id)); err != nil {
+		return err
+	}
+	s.forgetReconcileStatus(id)
+	s.forgetDiscoveredTargets(id)
 
-	cfg.ScrapeConfigs = append(cfg.ScrapeConfigs, scrapeConfig)
+	return respondOK(ctx, cfgFile)
+}
 
-	configYaml, err := yaml.Marshal(&cfg)
+func (s *Server) PostClustersAdd(ctx echo.Context, params v1.PostClustersAddParams) error {
+	var data v1.PostClustersAddJSONRequestBody
+	if err := ctx.Bind(&data); err != nil {
+		return err
+	}
+	seeds, err := clusterSeeds(ctx.Request().Context(), data.Hostname, &data.CouchbaseConfig)
 	if err != nil {
-		return fmt.Errorf("failed to marshal Prometheus config: %w", err)
+		return err
 	}
 
-	err = overwriteFileContents(cfgFile, configYaml)
+	conn, _, err := s.newClusterConnection(data.CouchbaseConfig)
 	if err != nil {
 		return err
 	}
+	// Any of the seeds will do, so that a cluster can be added while some of its nodes are down. Several clusters can
+	// report the same name (e.g. the default), so let the user pick one to tell them apart.
+	scrapeConfig, seed, err := s.createManagedScrapeConfig(ctx.Request().Context(), conn, seeds, "", data.Name,
+		data.MetricsConfig)
+	// The reconciler will fetch the targets again, so the cluster is still worth saving
+	if err != nil && !errors.Is(err, errTargetsUnknown) {
+		return err
+	}
 
-	return ctx.JSON(http.StatusOK, map[string]interface{}{
-		"ok": true,
-	})
+	cfgFile, cfg, err := s.openManagedConfig()
+	if err != nil {
+		return err
+	}
+	defer cfgFile.Close()
+	if err := checkIfMatch(cfgFile, params.IfMatch); err != nil {
+		return err
+	}
+
+	// The cluster UUID is stable, so adding the same cluster again will update its existing scrape config
+	var connStr string
+	if couchbase.IsConnectionString(data.Hostname) {
+		connStr = data.Hostname
+	}
+	scrapeConfig.Annotations = clusterAnnotations(seed, seeds, connStr, conn.network, data.MetricsConfig)
+	setJobName(scrapeConfig, managedJobName(cfg, serverJobPrefix, data.Name, scrapeConfig.ID))
+
+	// Couchbase Server metrics path is metrics
+	scrapeConfig.MetricsPath = "/metrics"
+
+	scrapeTLSConfig, tlsFiles, err := s.scrapeTLSConfig(scrapeConfig.JobName, data.CouchbaseConfig)
+	if err != nil {
+		return err
+	}
+	setTLSConfig(scrapeConfig, scrapeTLSConfig)
+
+	upsertManagedScrapeConfig(cfg, scrapeConfig)
+
+	if params.DryRun != nil && *params.DryRun {
+		return s.respondWithPreview(ctx, cfgFile, cfg, scrapeConfig)
+	}
+	if err := s.writeManagedConfig(cfgFile, cfg, tlsFiles, changeBy(ctx, "add cluster %s", scrapeConfig.ID)); err != nil {
+		return err
+	}
+
+	return respondOK(ctx, cfgFile)
 }
 
-func (s *Server) PostSgwAdd(ctx echo.Context) error {
+func (s *Server) PostSgwAdd(ctx echo.Context, params v1.PostSgwAddParams) error {
 	var data v1.PostSgwAddJSONRequestBody
 	// need to write new scrape config for SGW
 	if err := ctx.Bind(&data); err != nil {
 		return err
 	}
+	data.Hostname = couchbase.TrimBrackets(data.Hostname)
 
 	metricsPort := 4986
 
+	var name string
+	if data.Name != nil {
+		name = *data.Name
+	}
+
 	scrapeConfig := createScrapeConfigForSGW(
 		data.SgwConfig.Username,
 		data.SgwConfig.Password,
 		data.Hostname,
 		metricsPort,
+		name,
 	)
 
-	cfgPath := os.Getenv("PROMETHEUS_CONFIG_FILE")
-	if cfgPath == "" {
-		cfgPath = defaultPrometheusConfigPath
-	}
-	cfgFile, err := os.OpenFile(cfgPath, os.O_RDWR, 0)
+	cfgFile, cfg, err := s.openManagedConfig()
 	if err != nil {
-		return fmt.Errorf("failed to open Prometheus config: %w", err)
+		return err
 	}
 	defer cfgFile.Close()
-	existingConfig, err := io.ReadAll(cfgFile)
-	if err != nil {
-		return fmt.Errorf("failed to read Prometheus config: %w", err)
-	}
-	var cfg prometheus.Configuration
-	if err := yaml.Unmarshal(existingConfig, &cfg); err != nil {
-		return fmt.Errorf("failed to parse Prometheus config: %w", err)
+	if err := checkIfMatch(cfgFile, params.IfMatch); err != nil {
+		return err
 	}
 
-	// Job name needs to be unique
-	scrapeConfig.JobName = fmt.Sprintf("sync-gateway-managed-%d", len(cfg.ScrapeConfigs)+1)
+	// Sync Gateway has no cluster UUID, so the hostname is the closest thing to a stable identity
+	scrapeConfig.ID = data.Hostname
+	scrapeConfig.JobName = managedJobName(cfg, sgwJobPrefix, data.Name, scrapeConfig.ID)
 
 	// Sync Gateway metrics path is _metrics
 	scrapeConfig.MetricsPath = "/_metrics"
 
-	cfg.ScrapeConfigs = append(cfg.ScrapeConfigs, scrapeConfig)
+	upsertManagedScrapeConfig(cfg, scrapeConfig)
 
-	configYaml, err := yaml.Marshal(&cfg)
-	if err != nil {
-		return fmt.Errorf("failed to marshal Prometheus config: %w", err)
+	if params.DryRun != nil && *params.DryRun {
+		return s.respondWithPreview(ctx, cfgFile, cfg, scrapeConfig)
 	}
-
-	err = overwriteFileContents(cfgFile, configYaml)
-	if err != nil {
+	change := changeBy(ctx, "add Sync Gateway %s", scrapeConfig.ID)
+	if err := s.writeManagedConfig(cfgFile, cfg, nil, change); err != nil {
 		return err
 	}
 
-	return ctx.JSON(http.StatusOK, map[string]interface{}{
-		"ok": true,
-	})
+	return respondOK(ctx, cfgFile)
 }
 
 func (s *Server) PostCollectInformation(ctx echo.Context) error {
@@ -185,64 +340,562 @@ func (s *Server) PostCollectInformation(ctx echo.Context) error {
 	return ctx.Stream(http.StatusOK, "text/plain", stdout)
 }
 
-type MetricsConfig *struct {
-	MetricsPort *float32 `json:"metricsPort,omitempty"`
+// respondWithPreview responds with what a change would do to the config, without making it.
+func (s *Server) respondWithPreview(ctx echo.Context, cfgFile *configFile, cfg *prometheus.Configuration,
+	sc *prometheus.ScrapeConfig) error {
+	// Show the password files the change would use, without writing them
+	s.externalisePasswords(cfg)
+	preview, err := previewChange(cfgFile, cfg, sc)
+	if err != nil {
+		return err
+	}
+	ctx.Response().Header().Set("ETag", cfgFile.ETag())
+	return ctx.JSON(http.StatusOK, map[string]interface{}{
+		"ok":      true,
+		"preview": preview,
+	})
 }
 
-func createScrapeConfigForCluster(cluster *couchbase.PoolsDefault, useTLS bool, username, password string,
-	metricsConfig MetricsConfig) (*prometheus.ScrapeConfig, error) {
-	staticConfig := prometheus.StaticConfig{
-		Targets: make([]string, len(cluster.Nodes)),
-		Labels: map[string]string{
-			"cluster_name": cluster.ClusterName,
-		},
+// respondOK tells the client a change was made, and the version of the config it made.
+func respondOK(ctx echo.Context, cfgFile *configFile) error {
+	ctx.Response().Header().Set("ETag", cfgFile.ETag())
+	return ctx.JSON(http.StatusOK, map[string]interface{}{
+		"ok": true,
+	})
+}
+
+// couchbaseCredentials returns the username and password to authenticate to a cluster with. They are empty if the
+// cluster is to be authenticated to with a client certificate instead.
+func couchbaseCredentials(cbConfig v1.CouchbaseConfig) (string, string, error) {
+	var username, password string
+	if cbConfig.Username != nil {
+		username = *cbConfig.Username
+	}
+	if cbConfig.Password != nil {
+		password = *cbConfig.Password
+	}
+	if username != "" {
+		return username, password, nil
+	}
+	if settings := clusterTLSSettings(cbConfig); settings != nil && settings.ClientCert != nil &&
+		*settings.ClientCert != "" {
+		return "", "", nil
 	}
+	return "", "", echo.NewHTTPError(http.StatusBadRequest, "either a username and password, or a client "+
+		"certificate to connect over TLS with, is needed")
+}
+
+// clusterNetwork returns the network to scrape a cluster's nodes on, as given to the API.
+func clusterNetwork(cbConfig v1.CouchbaseConfig) (couchbase.Network, error) {
+	if cbConfig.Network == nil {
+		return couchbase.NetworkAuto, nil
+	}
+	switch network := couchbase.Network(*cbConfig.Network); network {
+	case couchbase.NetworkAuto, couchbase.NetworkDefault, couchbase.NetworkExternal:
+		return network, nil
+	}
+	return "", echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown network %q", *cbConfig.Network))
+}
+
+// couchbaseConnectionSettings returns the scheme, whether to use TLS, and the management port to use to contact a
+// cluster.
+func couchbaseConnectionSettings(cbConfig v1.CouchbaseConfig) (string, bool, int) {
+	scheme := "http"
+	useTLS := false
+	if cbConfig.UseTLS != nil && *cbConfig.UseTLS {
+		useTLS = true
+		scheme = "https"
+	}
+	mgmtPort := couchbase.DefaultMgmtPort
+	if useTLS {
+		mgmtPort = couchbase.DefaultMgmtSSLPort
+	}
+	if cbConfig.ManagementPort != nil {
+		mgmtPort = int(*cbConfig.ManagementPort)
+	}
+	return scheme, useTLS, mgmtPort
+}
 
-	var anyNodeCB7 bool
+// clusterSeeds returns the addresses to contact a cluster on, given the hostname or connection string it is added
+// with. A couchbases:// connection string turns on TLS, and its network option is used unless one is given.
+func clusterSeeds(ctx context.Context, hostname string, cbConfig *v1.CouchbaseConfig) ([]string, error) {
+	if !couchbase.IsConnectionString(hostname) {
+		_, _, mgmtPort := couchbaseConnectionSettings(*cbConfig)
+		// IPv6 addresses may be given in the brackets they need when followed by a port
+		return []string{net.JoinHostPort(couchbase.TrimBrackets(hostname), strconv.Itoa(mgmtPort))}, nil
+	}
 
-	for i, node := range cluster.Nodes {
-		hostname, mgmtPort, err := node.ResolveHostPort(useTLS)
+	connStr, err := couchbase.ParseConnectionString(hostname)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid connection string: %v", err))
+	}
+	if connStr.TLS {
+		useTLS := true
+		cbConfig.UseTLS = &useTLS
+	}
+	if network := connStr.Options.Get("network"); network != "" && cbConfig.Network == nil {
+		cbNetwork := v1.CouchbaseConfigNetwork(network)
+		cbConfig.Network = &cbNetwork
+	}
+	// The scheme decides the default port, so it is only known now
+	_, _, mgmtPort := couchbaseConnectionSettings(*cbConfig)
+	return connStr.Seeds(ctx, mgmtPort), nil
+}
+
+// connectionStringSeeds returns the addresses to contact a managed cluster on, starting with those its connection
+// string resolves to now, as its DNS SRV records may list other nodes than when it was added. They use the port the
+// cluster was last contacted on.
+func connectionStringSeeds(ctx context.Context, sc *prometheus.ScrapeConfig) []string {
+	seeds := seedAddresses(sc)
+	connStr, err := couchbase.ParseConnectionString(sc.Annotations[connectionStringAnnotation])
+	if err != nil {
+		return seeds
+	}
+	mgmtPort := couchbase.DefaultMgmtPort
+	if sc.Scheme == "https" {
+		mgmtPort = couchbase.DefaultMgmtSSLPort
+	}
+	if port := managementPort(seeds); port != nil {
+		mgmtPort = int(*port)
+	}
+
+	resolved := connStr.Seeds(ctx, mgmtPort)
+	known := make(map[string]bool, len(resolved))
+	for _, seed := range resolved {
+		known[seed] = true
+	}
+	for _, seed := range seeds {
+		if !known[seed] {
+			resolved = append(resolved, seed)
+		}
+	}
+	return resolved
+}
+
+// withPort returns addresses with their ports replaced.
+func withPort(addresses []string, port int) []string {
+	result := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		hostname, _, err := net.SplitHostPort(address)
+		if err != nil {
+			hostname = address
+		}
+		result = append(result, net.JoinHostPort(hostname, strconv.Itoa(port)))
+	}
+	return result
+}
+
+// clusterAnnotations returns the annotations for a Couchbase Server scrape config, given the address the cluster was
+// contacted on, all of those it can be contacted on, the connection string it was added with if any, and the network
+// its nodes are scraped on.
+func clusterAnnotations(seed string, seeds []string, connStr string, network couchbase.Network,
+	metricsConfig *v1.MetricsConfig) map[string]string {
+	annotations := map[string]string{
+		seedAnnotation: seed,
+	}
+	if connStr != "" {
+		annotations[connectionStringAnnotation] = connStr
+	}
+	if len(seeds) > 1 {
+		annotations[seedsAnnotation] = strings.Join(seeds, ",")
+	}
+	// Auto is chosen again each time, as the seed may change
+	if network != couchbase.NetworkAuto {
+		annotations[networkAnnotation] = string(network)
+	}
+	if metricsConfig != nil && metricsConfig.MetricsPort != nil {
+		annotations[metricsPortAnnotation] = fmt.Sprintf("%.0f", *metricsConfig.MetricsPort)
+	}
+	return annotations
+}
+
+// scrapeConfigNetwork returns the network a managed cluster's nodes are scraped on.
+func scrapeConfigNetwork(sc *prometheus.ScrapeConfig) couchbase.Network {
+	if network, ok := sc.Annotations[networkAnnotation]; ok {
+		return couchbase.Network(network)
+	}
+	return couchbase.NetworkAuto
+}
+
+// seedAddresses returns the addresses a managed cluster can be contacted on, if known, starting with the one it was
+// last contacted on.
+func seedAddresses(sc *prometheus.ScrapeConfig) []string {
+	var addresses []string
+	seed, ok := sc.Annotations[seedAnnotation]
+	if ok {
+		addresses = append(addresses, seed)
+	}
+	if seeds, ok := sc.Annotations[seedsAnnotation]; ok {
+		for _, address := range strings.Split(seeds, ",") {
+			if address != seed {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	return addresses
+}
+
+// clusterConnection is how to contact a cluster and scrape its nodes.
+type clusterConnection struct {
+	client   *couchbase.Client
+	scheme   string
+	useTLS   bool
+	network  couchbase.Network
+	username string
+	password string
+}
+
+// newClusterConnection returns how to contact a cluster with the settings it is added or updated with, along with the
+// management port to contact it on unless its addresses say otherwise.
+func (s *Server) newClusterConnection(cbConfig v1.CouchbaseConfig) (*clusterConnection, int, error) {
+	scheme, useTLS, mgmtPort := couchbaseConnectionSettings(cbConfig)
+	tlsConfig, err := clusterTLSConfig(cbConfig)
+	if err != nil {
+		return nil, 0, err
+	}
+	network, err := clusterNetwork(cbConfig)
+	if err != nil {
+		return nil, 0, err
+	}
+	username, password, err := couchbaseCredentials(cbConfig)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &clusterConnection{
+		client:   s.couchbaseClient(tlsConfig),
+		scheme:   scheme,
+		useTLS:   useTLS,
+		network:  network,
+		username: username,
+		password: password,
+	}, mgmtPort, nil
+}
+
+// createManagedScrapeConfig contacts a cluster on the first of the addresses that works, and creates its scrape config
+// with the cluster UUID as its ID. A cluster already managed under an ID must still be the same one. The cluster is
+// labelled with name instead of its own, if there is one, as the user may have chosen it to tell clusters apart.
+// The targets of a cluster using service discovery itself are fetched too; if that fails the scrape config is still
+// returned, along with an error wrapping errTargetsUnknown.
+func (s *Server) createManagedScrapeConfig(ctx context.Context, conn *clusterConnection, addresses []string, id string,
+	name *string, metricsConfig *v1.MetricsConfig) (*prometheus.ScrapeConfig, string, error) {
+	cluster, seed, err := fetchClusterFromAny(ctx, conn.client, conn.scheme, addresses, conn.username, conn.password)
+	if err != nil {
+		return nil, "", err
+	}
+	if cluster.UUID == "" {
+		return nil, "", echo.NewHTTPError(http.StatusBadRequest, "Couchbase Server did not report a cluster UUID")
+	}
+	if id != "" && cluster.UUID != id {
+		return nil, "", echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%q now belongs to cluster %q", id,
+			cluster.UUID))
+	}
+	if name != nil && *name != "" {
+		cluster.ClusterName = *name
+	}
+
+	scrapeConfig, err := createScrapeConfigForCluster(cluster, seed, conn.network, conn.useTLS, conn.username,
+		conn.password, metricsConfig)
+	if err != nil {
+		// The nodes cannot be scraped on the network asked for
+		return nil, "", echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("could not create scrape config: %v", err))
+	}
+	scrapeConfig.ID = cluster.UUID
+	if err := s.discoverTargets(ctx, conn.client, scrapeConfig.ID, scrapeConfig); err != nil {
+		s.logger.Sugar().Warnw("Failed to get targets of cluster", "id", scrapeConfig.ID, "err", err)
+		return scrapeConfig, seed, fmt.Errorf("%w: %v", errTargetsUnknown, err)
+	}
+	return scrapeConfig, seed, nil
+}
+
+// couchbaseClient returns a client to discover clusters with, which connects with the given TLS config.
+func (s *Server) couchbaseClient(tlsConfig *tls.Config) *couchbase.Client {
+	return couchbase.NewClient(s.opts.Discovery, tlsConfig)
+}
+
+// fetchClusterFromAny tries each of the given addresses in turn until one returns the cluster's information, and
+// returns it along with the address that worked.
+func fetchClusterFromAny(ctx context.Context, client *couchbase.Client, scheme string, addresses []string, username,
+	password string) (*couchbase.PoolsDefault, string, error) {
+	if len(addresses) == 0 {
+		return nil, "", echo.NewHTTPError(http.StatusBadRequest, "no addresses to contact the cluster on")
+	}
+	// If none of them work, report why the first failed, as that is the one most likely to be correct
+	var firstErr error
+	for _, address := range addresses {
+		cluster, err := fetchClusterFrom(ctx, client, scheme, address, username, password)
+		if err == nil {
+			return cluster, address, nil
+		}
+		// None of the others will be tried either
+		if ctx.Err() != nil {
+			return nil, "", err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, "", fmt.Errorf("unable to get cluster info: %w", firstErr)
+}
+
+func fetchClusterFrom(ctx context.Context, client *couchbase.Client, scheme, address, username,
+	password string) (*couchbase.PoolsDefault, error) {
+	hostname, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q: %w", address, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in address %q: %w", address, err)
+	}
+	return client.FetchCouchbaseClusterInfo(ctx, scheme, hostname, port, username, password)
+}
+
+// createScrapeConfigForCluster creates the scrape config for a cluster, given the address it was contacted on (the
+// seed) and the network to scrape its nodes on. Clusters that are entirely 7.1 or later can be asked for their own
+// targets, in which case the scrape config uses Couchbase Server's HTTP service discovery instead of a static list of
+// nodes.
+func createScrapeConfigForCluster(cluster *couchbase.PoolsDefault, seed string, network couchbase.Network,
+	useTLS bool, username, password string, metricsConfig *v1.MetricsConfig) (*prometheus.ScrapeConfig, error) {
+	network = cluster.ResolveNetwork(network, seed)
+
+	allNodesCB71 := len(cluster.Nodes) > 0
+	for _, node := range cluster.Nodes {
+		if !node.Version.AtLeast(cbvalue.Version7_1_0) {
+			allNodesCB71 = false
+		}
+	}
+	if allNodesCB71 {
+		return createHTTPSDScrapeConfigForCluster(cluster, seed, network, useTLS, username, password)
+	}
+
+	// Each node is a target group of its own, as its labels describe it. Nodes older than 7.0 are scraped through the
+	// exporter running alongside them, which serves plain HTTP and has credentials of its own, so they need a job of
+	// their own.
+	// A cluster of only older nodes has nothing to scrape itself, but its credentials are still kept to discover it with.
+	var scrapeConfig prometheus.ScrapeConfig
+	var exporterConfigs []prometheus.StaticConfig
+	for _, node := range cluster.Nodes {
+		target, err := nodeMetricsTarget(node, network, useTLS, metricsConfig)
 		if err != nil {
 			return nil, err
 		}
+		staticConfig := prometheus.StaticConfig{
+			Targets: []string{target},
+			Labels:  nodeLabels(cluster, node),
+		}
 		if node.Version.AtLeast(cbvalue.Version7_0_0) {
-			staticConfig.Targets[i] = fmt.Sprintf("%s:%d", hostname, mgmtPort)
-			anyNodeCB7 = true
-		} else if metricsConfig != nil && metricsConfig.MetricsPort != nil {
-			staticConfig.Targets[i] = fmt.Sprintf("%s:%.0f", hostname, *metricsConfig.MetricsPort)
+			scrapeConfig.StaticConfigs = append(scrapeConfig.StaticConfigs, staticConfig)
 		} else {
-			staticConfig.Targets[i] = fmt.Sprintf("%s:%d", hostname, 9091)
+			exporterConfigs = append(exporterConfigs, staticConfig)
 		}
 	}
 
-	scrapeConfig := prometheus.ScrapeConfig{
-		StaticConfigs: []prometheus.StaticConfig{staticConfig},
+	if useTLS {
+		scrapeConfig.Scheme = "https"
 	}
-	if anyNodeCB7 {
+	// Without a username the cluster is authenticated to with the client certificate in the TLS config instead
+	if username != "" {
 		scrapeConfig.HTTPClientConfig = prometheus.HTTPClientConfig{
-			BasicAuth: prometheus.BasicAuthConfig{
+			BasicAuth: &prometheus.BasicAuthConfig{
 				Username: username,
 				Password: password,
 			},
 		}
 	}
 
+	if len(exporterConfigs) > 0 {
+		scrapeConfig.Exporter = &prometheus.ScrapeConfig{
+			MetricsPath:   "/metrics",
+			StaticConfigs: exporterConfigs,
+		}
+		if exporterUsername, exporterPassword := exporterCredentials(metricsConfig); exporterUsername != "" {
+			scrapeConfig.Exporter.HTTPClientConfig = prometheus.HTTPClientConfig{
+				BasicAuth: &prometheus.BasicAuthConfig{
+					Username: exporterUsername,
+					Password: exporterPassword,
+				},
+			}
+		}
+	}
+
+	return &scrapeConfig, nil
+}
+
+// nodeLabels returns the labels of a node's target, which describe the node as well as the cluster it is part of.
+// Anything the node does not report is left out.
+func nodeLabels(cluster *couchbase.PoolsDefault, node couchbase.Node) map[string]string {
+	labels := map[string]string{
+		"cluster_name": cluster.ClusterName,
+	}
+	for name, value := range map[string]string{
+		"cluster_uuid":       cluster.UUID,
+		"node_uuid":          node.NodeUUID,
+		"otp_node":           node.OTPNode,
+		"server_group":       node.ServerGroup,
+		"cluster_membership": node.ClusterMembership,
+	} {
+		if value != "" {
+			labels[name] = value
+		}
+	}
+	if len(node.Services) > 0 {
+		services := append([]string(nil), node.Services...)
+		sort.Strings(services)
+		// Joined the way Prometheus joins Consul tags, so that any one service can be matched with e.g. ".*,kv,.*"
+		labels["services"] = "," + strings.Join(services, ",") + ","
+	}
+	return labels
+}
+
+// exporterCredentials returns the credentials of the exporter running alongside nodes older than 7.0, which are empty
+// if it needs none.
+func exporterCredentials(metricsConfig *v1.MetricsConfig) (string, string) {
+	var username, password string
+	if metricsConfig == nil {
+		return username, password
+	}
+	if metricsConfig.ExporterUsername != nil {
+		username = *metricsConfig.ExporterUsername
+	}
+	if metricsConfig.ExporterPassword != nil {
+		password = *metricsConfig.ExporterPassword
+	}
+	return username, password
+}
+
+// scrapeJobs returns the jobs of a managed scrape config: itself, and the job for its exporters if it has one.
+func scrapeJobs(sc *prometheus.ScrapeConfig) []*prometheus.ScrapeConfig {
+	if sc.Exporter == nil {
+		return []*prometheus.ScrapeConfig{sc}
+	}
+	return []*prometheus.ScrapeConfig{sc, sc.Exporter}
+}
+
+// setJobName sets the job name of a cluster's scrape config, and of the job for its exporters if it has one.
+func setJobName(sc *prometheus.ScrapeConfig, jobName string) {
+	sc.JobName = jobName
+	if sc.Exporter != nil {
+		sc.Exporter.JobName = jobName + exporterJobSuffix
+	}
+}
+
+// nodeMetricsTarget returns the address Prometheus scrapes a node on the given network: Couchbase Server itself from
+// 7.0, or the exporter running alongside older versions.
+func nodeMetricsTarget(node couchbase.Node, network couchbase.Network, useTLS bool,
+	metricsConfig *v1.MetricsConfig) (string, error) {
+	// The exporter serves plain HTTP, whether or not the cluster uses TLS
+	isCB70 := node.Version.AtLeast(cbvalue.Version7_0_0)
+	hostname, mgmtPort, err := node.ResolveHostPort(network, useTLS && isCB70)
+	if err != nil {
+		return "", err
+	}
+	if isCB70 {
+		return net.JoinHostPort(hostname, strconv.Itoa(mgmtPort)), nil
+	}
+	if metricsConfig != nil && metricsConfig.MetricsPort != nil {
+		return net.JoinHostPort(hostname, fmt.Sprintf("%.0f", *metricsConfig.MetricsPort)), nil
+	}
+	return net.JoinHostPort(hostname, "9091"), nil
+}
+
+// createHTTPSDScrapeConfigForCluster creates the scrape config for a cluster that reports its own targets. Couchbase
+// Server does not describe the nodes in them, so the labels of each node are added by matching its address, giving
+// them the same labels as a static list of nodes would have.
+func createHTTPSDScrapeConfigForCluster(cluster *couchbase.PoolsDefault, seed string, network couchbase.Network,
+	useTLS bool, username, password string) (*prometheus.ScrapeConfig, error) {
+	scheme, port := "http", "insecure"
+	if useTLS {
+		scheme, port = "https", "secure"
+	}
+	query := url.Values{
+		"clusterLabels": []string{"uuidOnly"},
+		"disposition":   []string{"inline"},
+		"port":          []string{port},
+		"type":          []string{"json"},
+	}
+	if network == couchbase.NetworkExternal {
+		query.Set("network", string(network))
+	}
+	sdURL := url.URL{
+		Scheme:   scheme,
+		Host:     seed,
+		Path:     "/prometheus_sd_config",
+		RawQuery: query.Encode(),
+	}
+	var auth prometheus.HTTPClientConfig
+	if username != "" {
+		auth.BasicAuth = &prometheus.BasicAuthConfig{
+			Username: username,
+			Password: password,
+		}
+	}
+
+	scrapeConfig := prometheus.ScrapeConfig{
+		HTTPClientConfig: auth,
+		HTTPSDConfigs: []prometheus.HTTPSDConfig{
+			{
+				URL:              sdURL.String(),
+				HTTPClientConfig: auth,
+			},
+		},
+		// The discovered targets only have the UUID, so add the name the same way as for static configs
+		RelabelConfigs: []prometheus.RelabelConfig{
+			{
+				TargetLabel: "cluster_name",
+				Replacement: cluster.ClusterName,
+			},
+		},
+	}
+	for _, node := range cluster.Nodes {
+		target, err := nodeMetricsTarget(node, network, useTLS, nil)
+		if err != nil {
+			return nil, err
+		}
+		labels := nodeLabels(cluster, node)
+		names := make([]string, 0, len(labels))
+		for name := range labels {
+			if name != "cluster_name" && name != "cluster_uuid" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			scrapeConfig.RelabelConfigs = append(scrapeConfig.RelabelConfigs, prometheus.RelabelConfig{
+				SourceLabels: []string{addressLabel},
+				Regex:        regexp.QuoteMeta(target),
+				TargetLabel:  name,
+				Replacement:  labels[name],
+			})
+		}
+	}
+	if useTLS {
+		scrapeConfig.Scheme = "https"
+	}
 	return &scrapeConfig, nil
 }
 
 func createScrapeConfigForSGW(username, password string, hostname string,
-	metricsPort int) *prometheus.ScrapeConfig {
+	metricsPort int, name string) *prometheus.ScrapeConfig {
 	staticConfig := prometheus.StaticConfig{
 		Targets: make([]string, 1),
+		Labels: map[string]string{
+			"sgw_cluster": hostname,
+		},
+	}
+	// A named Sync Gateway is also labelled with cluster_name, so that it shows up alongside its Couchbase cluster in
+	// the dashboards
+	if name != "" {
+		staticConfig.Labels["sgw_cluster"] = name
+		staticConfig.Labels["cluster_name"] = name
 	}
 
-	staticConfig.Targets[0] = fmt.Sprintf("%s:%d", hostname, metricsPort)
+	staticConfig.Targets[0] = net.JoinHostPort(hostname, strconv.Itoa(metricsPort))
 
 	scrapeConfig := prometheus.ScrapeConfig{
 		StaticConfigs: []prometheus.StaticConfig{staticConfig},
 	}
 	scrapeConfig.HTTPClientConfig = prometheus.HTTPClientConfig{
-		BasicAuth: prometheus.BasicAuthConfig{
+		BasicAuth: &prometheus.BasicAuthConfig{
 			Username: username,
 			Password: password,
 		},
@@ -251,24 +904,183 @@ func createScrapeConfigForSGW(username, password string, hostname string,
 	return &scrapeConfig
 }
 
-func overwriteFileContents(file *os.File, contents []byte) error {
-	if err := file.Truncate(0); err != nil {
-		return fmt.Errorf("failed to truncate Prometheus config: err")
+// managedClusterFromScrapeConfig summarises a managed scrape config for the API. It must never include credentials.
+func managedClusterFromScrapeConfig(sc *prometheus.ScrapeConfig) v1.ManagedCluster {
+	cluster := v1.ManagedCluster{
+		Id:          sc.ID,
+		Kind:        v1.ManagedClusterKindCouchbaseServer,
+		MetricsPath: sc.MetricsPath,
+		Targets:     make([]string, 0),
+		UseTLS:      sc.Scheme == "https",
+	}
+	// Scrape configs written before IDs were introduced are identified by their job name instead
+	if cluster.Id == "" {
+		cluster.Id = sc.JobName
+	}
+	if strings.HasPrefix(sc.JobName, sgwJobPrefix) {
+		cluster.Kind = v1.ManagedClusterKindSyncGateway
+	}
+	for _, relabelConfig := range sc.RelabelConfigs {
+		if relabelConfig.TargetLabel == "cluster_name" && len(relabelConfig.SourceLabels) == 0 && cluster.Name == nil {
+			name := relabelConfig.Replacement
+			cluster.Name = &name
+		}
+	}
+	for _, sdConfig := range sc.HTTPSDConfigs {
+		sdURL := sdConfig.URL
+		cluster.HttpSDURL = &sdURL
 	}
+	for _, job := range scrapeJobs(sc) {
+		for _, staticConfig := range job.StaticConfigs {
+			cluster.Targets = append(cluster.Targets, staticConfig.Targets...)
+			if name, ok := staticConfig.Labels["cluster_name"]; ok && cluster.Name == nil {
+				cluster.Name = &name
+			} else if name, ok := staticConfig.Labels["sgw_cluster"]; ok && cluster.Name == nil {
+				cluster.Name = &name
+			}
+		}
+	}
+	return cluster
+}
 
-	if _, err := file.Seek(0, 0); err != nil {
-		return fmt.Errorf("failed to seek Prometheus config: %w", err)
+// findManagedScrapeConfig returns the index of the managed scrape config with the given ID, or -1 if there is none.
+func findManagedScrapeConfig(cfg *prometheus.Configuration, id string) int {
+	for i, sc := range cfg.ScrapeConfigs {
+		if managedClusterFromScrapeConfig(sc).Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// managedJobName returns the job name to use for a managed scrape config. This is derived from the user-supplied name
+// where there is one, falling back to the ID, and is kept unique across all the scrape configs, counting the exporter
+// jobs of clusters, and the exporter job the new one may get.
+func managedJobName(cfg *prometheus.Configuration, prefix string, name *string, id string) string {
+	jobName := prefix + id
+	named := false
+	if name != nil {
+		if slug := strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(*name), "-"), "-"); slug != "" {
+			jobName = prefix + slug
+			named = true
+		}
+	}
+	taken := func(candidate string) bool {
+		for _, sc := range cfg.ScrapeConfigs {
+			if sc.ID == id {
+				continue
+			}
+			if sc.JobName == candidate || sc.JobName == candidate+exporterJobSuffix ||
+				(sc.Exporter != nil && sc.Exporter.JobName == candidate) {
+				return true
+			}
+		}
+		return false
+	}
+	if !taken(jobName) {
+		return jobName
+	}
+	if named && !taken(jobName+"-"+id) {
+		return jobName + "-" + id
+	}
+	for i := 2; ; i++ {
+		if candidate := fmt.Sprintf("%s-%d", jobName, i); !taken(candidate) {
+			return candidate
+		}
 	}
+}
+
+// upsertManagedScrapeConfig replaces the managed scrape config with the same ID as sc, or adds sc if there is none.
+func upsertManagedScrapeConfig(cfg *prometheus.Configuration, sc *prometheus.ScrapeConfig) {
+	if idx := findManagedScrapeConfig(cfg, sc.ID); idx != -1 {
+		cfg.ScrapeConfigs[idx] = sc
+		return
+	}
+	cfg.ScrapeConfigs = append(cfg.ScrapeConfigs, sc)
+}
 
-	if _, err := file.Write(contents); err != nil {
-		return fmt.Errorf("failed to write Prometheus config: %w", err)
+// writeManagedConfig marshals cfg and writes it over the file opened by openManagedConfig, along with the given secret
+// files, see writeManagedContents.
+func (s *Server) writeManagedConfig(cfgFile *configFile, cfg *prometheus.Configuration, secrets map[string][]byte,
+	change configChange) error {
+	// This also moves out the passwords of scrape configs written before password files were used
+	files := s.externalisePasswords(cfg)
+	for path, contents := range secrets {
+		files[path] = contents
 	}
-	if err := file.Close(); err != nil {
-		return fmt.Errorf("failed to close Prometheus config: %w", err)
+	configYaml, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+	return s.writeManagedContents(cfgFile, configYaml, cfg, files, change)
+}
+
+// writeManagedContents writes contents, which parse to cfg, over the file opened by openManagedConfig and records the
+// change in the history. The secret files the config refers to are written first, and put back as they were if the
+// config is not written. The Prometheus config is checked and loaded by Prometheus, or in file SD mode the file
+// service discovery files are brought up to date.
+func (s *Server) writeManagedContents(cfgFile *configFile, contents []byte, cfg *prometheus.Configuration,
+	secrets map[string][]byte, change configChange) error {
+	restoreSecrets, err := s.writeSecretFiles(secrets)
+	if err != nil {
+		return err
+	}
+	switch s.opts.TargetMode {
+	case TargetModeFileSD, TargetModeHTTPSD:
+		err = cfgFile.write(contents)
+	default:
+		err = s.writePrometheusConfig(cfgFile, contents)
+	}
+	if err != nil {
+		restoreSecrets()
+		return err
+	}
+	if err := s.recordRevision(cfgFile, change); err != nil {
+		// The change has been made, only its history is missing
+		s.logger.Sugar().Warnw("Failed to record config revision", "err", err)
+	}
+	if err := s.removeUnusedSecretFiles(cfg); err != nil {
+		s.logger.Sugar().Warnw("Failed to remove unused secret files", "err", err)
+	}
+	if s.opts.TargetMode == TargetModeFileSD {
+		return s.writeTargetFiles(cfg)
 	}
 	return nil
 }
 
+// writePrometheusConfig replaces the Prometheus config with contents, having checked them with promtool first, and has
+// Prometheus reload it. If Prometheus does not load it, the previous config is restored, so that Prometheus is never
+// left with a config file it will refuse on its next restart.
+func (s *Server) writePrometheusConfig(cfgFile *configFile, contents []byte) error {
+	if s.opts.PromtoolPath != "" {
+		if err := prometheus.CheckConfig(s.opts.PromtoolPath, cfgFile.Name(), contents); err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("the Prometheus config was not "+
+				"changed, as the new config would be rejected: %v", err))
+		}
+	}
+	if err := cfgFile.write(contents); err != nil {
+		return err
+	}
+	if s.opts.PrometheusURL == "" {
+		return nil
+	}
+
+	client := prometheus.NewClient(s.opts.PrometheusURL)
+	reloadErr := client.ReloadAndVerify(context.Background(), cfgFile.Name(), contents)
+	if reloadErr == nil {
+		return nil
+	}
+	if err := cfgFile.write(cfgFile.contents); err != nil {
+		return fmt.Errorf("prometheus did not load the new config (%v), and restoring the previous one failed: %w",
+			reloadErr, err)
+	}
+	if err := client.Reload(context.Background()); err != nil {
+		s.logger.Sugar().Warnw("Failed to reload the restored Prometheus config", "err", err)
+	}
+	return echo.NewHTTPError(http.StatusBadGateway, fmt.Sprintf("Prometheus did not load the new config, so the "+
+		"previous one was restored: %v", reloadErr))
+}
+
 func (s *Server) GetOpenapiJson(ctx echo.Context) error { //nolint:revive
 	swagger, err := v1.GetSwagger()
 	if err != nil {
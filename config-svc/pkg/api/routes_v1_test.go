@@ -16,20 +16,27 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 
 	"github.com/couchbase/tools-common/cbrest"
 	"github.com/couchbase/tools-common/cbvalue"
+	v1 "github.com/couchbaselabs/observability/config-svc/pkg/api/v1"
 	"github.com/couchbaselabs/observability/config-svc/pkg/couchbase"
+	"github.com/couchbaselabs/observability/config-svc/pkg/prometheus"
+	"github.com/couchbaselabs/observability/config-svc/pkg/prometheus/prometheustest"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
 )
 
 const basePromConfig = `global:
@@ -43,11 +50,44 @@ scrape_configs:
           labels:
             foo: bar
             test: label
+    - job_name: test-file-sd
+      file_sd_configs:
+        - files:
+            - couchbase/custom/*.json
+`
+
+const testClusterUUID = "6d3e2b8a"
+
+// managedPromConfig is appended to basePromConfig to give a config with one managed cluster and one Sync Gateway.
+const managedPromConfig = `    # CMOS managed
+    - job_name: couchbase-server-managed-1
+      metrics_path: /metrics
+      scheme: https
+      basic_auth:
+        username: Administrator
+        password: asdasd
+      static_configs:
+        - targets:
+            - test1:18091
+            - test2:18091
+          labels:
+            cluster_name: Test Cluster
+    # CMOS managed
+    - job_name: sync-gateway-managed-2
+      metrics_path: /_metrics
+      basic_auth:
+        username: Administrator
+        password: asdasd
+      static_configs:
+        - targets:
+            - test:4986
+          labels: {}
 `
 
 func TestPostClustersAdd(t *testing.T) {
 	t.Run("CreateConfig", func(t *testing.T) {
 		promCfgPath, testCluster := setupForTest(t, cbrest.TestClusterOptions{
+			UUID: testClusterUUID,
 			Handlers: map[string]http.HandlerFunc{
 				"GET:/pools/default": func(w http.ResponseWriter, r *http.Request) {
 					w.WriteHeader(http.StatusOK)
@@ -84,15 +124,15 @@ func TestPostClustersAdd(t *testing.T) {
 			production: true,
 		}
 
-		err := h.PostClustersAdd(ctx)
+		err := h.PostClustersAdd(ctx, v1.PostClustersAddParams{})
 		require.NoError(t, err)
 
 		require.Equal(t, http.StatusOK, rec.Code)
 
 		result, err := os.ReadFile(promCfgPath)
 		require.NoError(t, err)
-		require.Equal(t, basePromConfig+`    # CMOS managed
-    - job_name: couchbase-server-managed-1
+		require.Equal(t, basePromConfig+fmt.Sprintf(`    # CMOS managed: 6d3e2b8a seed=localhost:%d
+    - job_name: couchbase-server-managed-6d3e2b8a
       metrics_path: /metrics
       basic_auth:
         username: Administrator
@@ -102,11 +142,13 @@ func TestPostClustersAdd(t *testing.T) {
             - test:8091
           labels:
             cluster_name: Test Cluster
-`, string(result))
+            cluster_uuid: 6d3e2b8a
+`, testCluster.Port()), string(result))
 	})
 
 	t.Run("CreateConfigCustomMetricsPort", func(t *testing.T) {
 		promCfgPath, testCluster := setupForTest(t, cbrest.TestClusterOptions{
+			UUID: testClusterUUID,
 			Handlers: map[string]http.HandlerFunc{
 				"GET:/pools/default": func(w http.ResponseWriter, r *http.Request) {
 					w.WriteHeader(http.StatusOK)
@@ -146,40 +188,150 @@ func TestPostClustersAdd(t *testing.T) {
 			production: true,
 		}
 
-		err := h.PostClustersAdd(ctx)
+		err := h.PostClustersAdd(ctx, v1.PostClustersAddParams{})
 		require.NoError(t, err)
 
 		require.Equal(t, http.StatusOK, rec.Code)
 
 		result, err := os.ReadFile(promCfgPath)
 		require.NoError(t, err)
-		require.Equal(t, basePromConfig+`    # CMOS managed
-    - job_name: couchbase-server-managed-1
+		// The cluster's credentials are kept to discover it with, but not used to scrape the exporter
+		require.Equal(t, basePromConfig+fmt.Sprintf(`    # CMOS managed: 6d3e2b8a metricsPort=9999 seed=localhost:%d
+    - job_name: couchbase-server-managed-6d3e2b8a
       metrics_path: /metrics
       basic_auth:
-        username: ""
-        password: ""
+        username: Administrator
+        password: asdasd
+    # CMOS managed: 6d3e2b8a part=exporter
+    - job_name: couchbase-server-managed-6d3e2b8a-exporter
+      metrics_path: /metrics
       static_configs:
         - targets:
             - test:9999
           labels:
             cluster_name: Test Cluster
-`, string(result))
+            cluster_uuid: 6d3e2b8a
+`, testCluster.Port()), string(result))
 	})
-}
 
-func TestPostSgwAdd(t *testing.T) {
-	t.Run("CreateConfig", func(t *testing.T) {
-		promCfgPath := setupForSGWTest(t)
+	t.Run("CreateConfigMixedVersions", func(t *testing.T) {
+		promCfgPath, testCluster := setupForTest(t, cbrest.TestClusterOptions{
+			UUID: testClusterUUID,
+			Handlers: map[string]http.HandlerFunc{
+				"GET:/pools/default": func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+					_ = json.NewEncoder(w).Encode(&couchbase.PoolsDefault{
+						ClusterName: "Test Cluster",
+						Nodes: []couchbase.Node{
+							{
+								Hostname: "new:8091",
+								Version:  cbvalue.Version7_0_0,
+							},
+							{
+								Hostname: "old:8091",
+								Version:  cbvalue.Version6_6_0,
+							},
+						},
+					})
+				},
+			},
+		})
+		defer testCluster.Close()
 
 		e := echo.New()
-		req := httptest.NewRequest(http.MethodPost, "/api/v1/sgw/add", bytes.NewReader([]byte(fmt.Sprintf(`{
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/clusters/add", bytes.NewReader([]byte(fmt.Sprintf(`{
 			"hostname": "%s",
-			"sgwConfig": {
+			"couchbaseConfig": {
 				"username": "Administrator",
-				"password": "asdasd"
+				"password": "asdasd",
+				"managementPort": %d
+			},
+			"metricsConfig": {
+				"exporterUsername": "exporter",
+				"exporterPassword": "exporter-password"
 			}
-		}`, "test"))))
+		}`, testCluster.Hostname(), testCluster.Port()))))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		h := &Server{
+			baseLogger: zap.NewNop(),
+			logger:     zap.NewNop(),
+			echo:       e,
+			production: true,
+		}
+		require.NoError(t, h.PostClustersAdd(e.NewContext(req, rec), v1.PostClustersAddParams{}))
+
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Equal(t, basePromConfig+fmt.Sprintf(`    # CMOS managed: 6d3e2b8a seed=localhost:%d
+    - job_name: couchbase-server-managed-6d3e2b8a
+      metrics_path: /metrics
+      basic_auth:
+        username: Administrator
+        password: asdasd
+      static_configs:
+        - targets:
+            - new:8091
+          labels:
+            cluster_name: Test Cluster
+            cluster_uuid: 6d3e2b8a
+    # CMOS managed: 6d3e2b8a part=exporter
+    - job_name: couchbase-server-managed-6d3e2b8a-exporter
+      metrics_path: /metrics
+      basic_auth:
+        username: exporter
+        password: exporter-password
+      static_configs:
+        - targets:
+            - old:9091
+          labels:
+            cluster_name: Test Cluster
+            cluster_uuid: 6d3e2b8a
+`, testCluster.Port()), string(result))
+
+		// Both jobs make up the one managed cluster
+		cfgFile, cfg, err := h.openManagedConfig()
+		require.NoError(t, err)
+		cfgFile.Close()
+		require.Len(t, cfg.ScrapeConfigs, 1)
+		require.Equal(t, []string{"new:8091", "old:9091"}, managedClusterFromScrapeConfig(cfg.ScrapeConfigs[0]).Targets)
+	})
+
+	t.Run("CreateConfigHTTPSD", func(t *testing.T) {
+		promCfgPath, testCluster := setupForTest(t, cbrest.TestClusterOptions{
+			UUID: testClusterUUID,
+			Handlers: map[string]http.HandlerFunc{
+				"GET:/pools/default": func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+					_ = json.NewEncoder(w).Encode(&couchbase.PoolsDefault{
+						ClusterName: "Test Cluster",
+						Nodes: []couchbase.Node{
+							{
+								Hostname: "test1",
+								Version:  cbvalue.Version7_1_0,
+								NodeUUID: "node1",
+								Services: []string{"n1ql", "kv"},
+							},
+							{
+								Hostname: "test2",
+								Version:  cbvalue.Version7_1_0,
+							},
+						},
+					})
+				},
+			},
+		})
+		defer testCluster.Close()
+
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/clusters/add", bytes.NewReader([]byte(fmt.Sprintf(`{
+			"hostname": "%s",
+			"couchbaseConfig": {
+				"username": "Administrator",
+				"password": "asdasd",
+				"managementPort": %d
+			}
+		}`, testCluster.Hostname(), testCluster.Port()))))
 		req.Header.Set("Content-Type", "application/json")
 		rec := httptest.NewRecorder()
 		ctx := e.NewContext(req, rec)
@@ -190,24 +342,1278 @@ func TestPostSgwAdd(t *testing.T) {
 			production: true,
 		}
 
-		err := h.PostSgwAdd(ctx)
+		err := h.PostClustersAdd(ctx, v1.PostClustersAddParams{})
 		require.NoError(t, err)
 
 		require.Equal(t, http.StatusOK, rec.Code)
 
 		result, err := os.ReadFile(promCfgPath)
 		require.NoError(t, err)
-		require.Equal(t, basePromConfig+`    # CMOS managed
-    - job_name: sync-gateway-managed-1
-      metrics_path: /_metrics
+		require.Equal(t, basePromConfig+fmt.Sprintf(`    # CMOS managed: 6d3e2b8a seed=localhost:%[1]d
+    - job_name: couchbase-server-managed-6d3e2b8a
+      metrics_path: /metrics
+      basic_auth:
+        username: Administrator
+        password: asdasd
+      http_sd_configs:
+        - url: http://localhost:%[1]d/prometheus_sd_config?`+
+			`clusterLabels=uuidOnly&disposition=inline&port=insecure&type=json
+          basic_auth:
+            username: Administrator
+            password: asdasd
+      relabel_configs:
+        - target_label: cluster_name
+          replacement: Test Cluster
+        - source_labels:
+            - __address__
+          regex: test1:8091
+          target_label: node_uuid
+          replacement: node1
+        - source_labels:
+            - __address__
+          regex: test1:8091
+          target_label: services
+          replacement: ',kv,n1ql,'
+`, testCluster.Port()), string(result))
+	})
+
+	t.Run("CreateConfigWithName", func(t *testing.T) {
+		promCfgPath, testCluster := setupForTest(t, cbrest.TestClusterOptions{
+			UUID: testClusterUUID,
+			Handlers: map[string]http.HandlerFunc{
+				"GET:/pools/default": func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+					_ = json.NewEncoder(w).Encode(&couchbase.PoolsDefault{
+						ClusterName: "Test Cluster",
+						Nodes: []couchbase.Node{
+							{
+								Hostname: "test",
+								Version:  cbvalue.Version7_0_0,
+							},
+						},
+					})
+				},
+			},
+		})
+		defer testCluster.Close()
+		// Another cluster already has the name, so the job name needs disambiguating
+		require.NoError(t, os.WriteFile(promCfgPath, []byte(basePromConfig+`    # CMOS managed: 1f0a9c7e
+    - job_name: couchbase-server-managed-prod-east
+      metrics_path: /metrics
       basic_auth:
         username: Administrator
         password: asdasd
       static_configs:
         - targets:
-            - test:4986
-          labels: {}
-`, string(result))
+            - other:8091
+          labels:
+            cluster_name: Prod East
+`), 0o666))
+
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/clusters/add", bytes.NewReader([]byte(fmt.Sprintf(`{
+			"name": "Prod East",
+			"hostname": "%s",
+			"couchbaseConfig": {
+				"username": "Administrator",
+				"password": "asdasd",
+				"managementPort": %d
+			}
+		}`, testCluster.Hostname(), testCluster.Port()))))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+		h := &Server{
+			baseLogger: zap.NewNop(),
+			logger:     zap.NewNop(),
+			echo:       e,
+			production: true,
+		}
+
+		err := h.PostClustersAdd(ctx, v1.PostClustersAddParams{})
+		require.NoError(t, err)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Equal(t, basePromConfig+fmt.Sprintf(`    # CMOS managed: 1f0a9c7e
+    - job_name: couchbase-server-managed-prod-east
+      metrics_path: /metrics
+      basic_auth:
+        username: Administrator
+        password: asdasd
+      static_configs:
+        - targets:
+            - other:8091
+          labels:
+            cluster_name: Prod East
+    # CMOS managed: 6d3e2b8a seed=localhost:%d
+    - job_name: couchbase-server-managed-prod-east-6d3e2b8a
+      metrics_path: /metrics
+      basic_auth:
+        username: Administrator
+        password: asdasd
+      static_configs:
+        - targets:
+            - test:8091
+          labels:
+            cluster_name: Prod East
+            cluster_uuid: 6d3e2b8a
+`, testCluster.Port()), string(result))
+	})
+
+	t.Run("ReAddUpdatesExisting", func(t *testing.T) {
+		promCfgPath, testCluster := setupForTest(t, cbrest.TestClusterOptions{
+			UUID: testClusterUUID,
+			Handlers: map[string]http.HandlerFunc{
+				"GET:/pools/default": func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+					_ = json.NewEncoder(w).Encode(&couchbase.PoolsDefault{
+						ClusterName: "Test Cluster",
+						Nodes: []couchbase.Node{
+							{
+								Hostname: "test",
+								Version:  cbvalue.Version7_0_0,
+							},
+						},
+					})
+				},
+			},
+		})
+		defer testCluster.Close()
+		require.NoError(t, os.WriteFile(promCfgPath, []byte(basePromConfig+`    # CMOS managed: 6d3e2b8a
+    - job_name: couchbase-server-managed-6d3e2b8a
+      metrics_path: /metrics
+      basic_auth:
+        username: Administrator
+        password: oldpassword
+      static_configs:
+        - targets:
+            - old:8091
+          labels:
+            cluster_name: Test Cluster
+`), 0o666))
+
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/clusters/add", bytes.NewReader([]byte(fmt.Sprintf(`{
+			"hostname": "%s",
+			"couchbaseConfig": {
+				"username": "Administrator",
+				"password": "asdasd",
+				"managementPort": %d
+			}
+		}`, testCluster.Hostname(), testCluster.Port()))))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+		h := &Server{
+			baseLogger: zap.NewNop(),
+			logger:     zap.NewNop(),
+			echo:       e,
+			production: true,
+		}
+
+		err := h.PostClustersAdd(ctx, v1.PostClustersAddParams{})
+		require.NoError(t, err)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Equal(t, basePromConfig+fmt.Sprintf(`    # CMOS managed: 6d3e2b8a seed=localhost:%d
+    - job_name: couchbase-server-managed-6d3e2b8a
+      metrics_path: /metrics
+      basic_auth:
+        username: Administrator
+        password: asdasd
+      static_configs:
+        - targets:
+            - test:8091
+          labels:
+            cluster_name: Test Cluster
+            cluster_uuid: 6d3e2b8a
+`, testCluster.Port()), string(result))
+	})
+}
+
+func TestCreateScrapeConfigNodeLabels(t *testing.T) {
+	// An abridged /pools/default of a 7.0 cluster with its nodes in two server groups
+	const poolsDefault = `{
+		"clusterName": "Prod",
+		"nodes": [
+			{
+				"hostname": "10.0.0.1:8091",
+				"version": "7.0.2-6703-enterprise",
+				"services": ["n1ql", "kv", "index"],
+				"serverGroup": "Group 1",
+				"otpNode": "ns_1@10.0.0.1",
+				"nodeUUID": "a1b2c3",
+				"clusterMembership": "active",
+				"thisNode": true
+			},
+			{
+				"hostname": "10.0.0.2:8091",
+				"version": "7.0.2-6703-enterprise",
+				"services": ["fts"],
+				"serverGroup": "Group 2",
+				"otpNode": "ns_1@10.0.0.2",
+				"nodeUUID": "d4e5f6",
+				"clusterMembership": "inactiveAdded"
+			}
+		]
+	}`
+	var cluster couchbase.PoolsDefault
+	require.NoError(t, json.Unmarshal([]byte(poolsDefault), &cluster))
+	cluster.UUID = testClusterUUID
+
+	scrapeConfig, err := createScrapeConfigForCluster(&cluster, "10.0.0.1:8091", couchbase.NetworkAuto, false,
+		"Administrator", "password", nil)
+	require.NoError(t, err)
+	require.Equal(t, []prometheus.StaticConfig{
+		{
+			Targets: []string{"10.0.0.1:8091"},
+			Labels: map[string]string{
+				"cluster_name":       "Prod",
+				"cluster_uuid":       testClusterUUID,
+				"node_uuid":          "a1b2c3",
+				"otp_node":           "ns_1@10.0.0.1",
+				"server_group":       "Group 1",
+				"cluster_membership": "active",
+				"services":           ",index,kv,n1ql,",
+			},
+		},
+		{
+			Targets: []string{"10.0.0.2:8091"},
+			Labels: map[string]string{
+				"cluster_name":       "Prod",
+				"cluster_uuid":       testClusterUUID,
+				"node_uuid":          "d4e5f6",
+				"otp_node":           "ns_1@10.0.0.2",
+				"server_group":       "Group 2",
+				"cluster_membership": "inactiveAdded",
+				"services":           ",fts,",
+			},
+		},
+	}, scrapeConfig.StaticConfigs)
+}
+
+func TestCreateScrapeConfigExporter(t *testing.T) {
+	cluster := couchbase.PoolsDefault{
+		ClusterName: "Prod",
+		UUID:        testClusterUUID,
+		Nodes: []couchbase.Node{
+			{
+				Hostname: "10.0.0.1:8091",
+				Version:  cbvalue.Version7_0_0,
+			},
+			{
+				Hostname: "10.0.0.2:8091",
+				Version:  cbvalue.Version6_6_0,
+			},
+		},
+	}
+
+	t.Run("TLS", func(t *testing.T) {
+		scrapeConfig, err := createScrapeConfigForCluster(&cluster, "10.0.0.1:18091", couchbase.NetworkAuto, true,
+			"Administrator", "password", nil)
+		require.NoError(t, err)
+		setTLSConfig(scrapeConfig, &prometheus.TLSConfig{CAFile: "ca.pem"})
+
+		require.Equal(t, "https", scrapeConfig.Scheme)
+		require.NotNil(t, scrapeConfig.HTTPClientConfig.TLSConfig)
+		require.Equal(t, []string{"10.0.0.1:18091"}, scrapeConfig.StaticConfigs[0].Targets)
+		// The exporter serves plain HTTP, and knows nothing of the cluster's credentials
+		require.NotNil(t, scrapeConfig.Exporter)
+		require.Empty(t, scrapeConfig.Exporter.Scheme)
+		require.Nil(t, scrapeConfig.Exporter.HTTPClientConfig.TLSConfig)
+		require.Nil(t, scrapeConfig.Exporter.HTTPClientConfig.BasicAuth)
+		require.Equal(t, []string{"10.0.0.2:9091"}, scrapeConfig.Exporter.StaticConfigs[0].Targets)
+	})
+
+	t.Run("NoOlderNodes", func(t *testing.T) {
+		upgraded := cluster
+		upgraded.Nodes = cluster.Nodes[:1]
+		exporterUsername := "exporter"
+		scrapeConfig, err := createScrapeConfigForCluster(&upgraded, "10.0.0.1:8091", couchbase.NetworkAuto, false,
+			"Administrator", "password", &v1.MetricsConfig{ExporterUsername: &exporterUsername})
+		require.NoError(t, err)
+		require.Nil(t, scrapeConfig.Exporter)
+	})
+}
+
+func TestPostClustersAddNetwork(t *testing.T) {
+	externalPorts := `{"mgmt": 30091}`
+	promCfgPath, testCluster := setupForTest(t, cbrest.TestClusterOptions{
+		UUID: testClusterUUID,
+		Handlers: map[string]http.HandlerFunc{
+			"GET:/pools/default": func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				_, _ = w.Write([]byte(fmt.Sprintf(`{
+					"clusterName": "Test Cluster",
+					"nodes": [
+						{
+							"hostname": "test:8091",
+							"version": "7.0.0",
+							"alternateAddresses": {
+								"external": {"hostname": "ext.example.com", "ports": %s}
+							}
+						}
+					]
+				}`, externalPorts)))
+			},
+		},
+	})
+	defer testCluster.Close()
+
+	addCluster := func(couchbaseConfig string) error {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/clusters/add", bytes.NewReader([]byte(fmt.Sprintf(`{
+			"hostname": "%s",
+			"couchbaseConfig": {
+				"username": "Administrator",
+				"password": "asdasd",
+				"managementPort": %d,
+				%s
+			}
+		}`, testCluster.Hostname(), testCluster.Port(), couchbaseConfig))))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		h := &Server{
+			baseLogger: zap.NewNop(),
+			logger:     zap.NewNop(),
+			echo:       e,
+			production: true,
+		}
+		return h.PostClustersAdd(e.NewContext(req, rec), v1.PostClustersAddParams{})
+	}
+	expectTarget := func(t *testing.T, annotations, target string) {
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Equal(t, basePromConfig+fmt.Sprintf(`    # CMOS managed: 6d3e2b8a %sseed=localhost:%d
+    - job_name: couchbase-server-managed-6d3e2b8a
+      metrics_path: /metrics
+      basic_auth:
+        username: Administrator
+        password: asdasd
+      static_configs:
+        - targets:
+            - %s
+          labels:
+            cluster_name: Test Cluster
+            cluster_uuid: 6d3e2b8a
+`, annotations, testCluster.Port(), target), string(result))
+	}
+
+	t.Run("Auto", func(t *testing.T) {
+		// The cluster was contacted on neither address, so as with the SDKs the nodes' own are used
+		require.NoError(t, addCluster(`"network": "auto"`))
+		expectTarget(t, "", "test:8091")
+	})
+
+	t.Run("External", func(t *testing.T) {
+		require.NoError(t, addCluster(`"network": "external"`))
+		expectTarget(t, "network=external ", "ext.example.com:30091")
+	})
+
+	t.Run("ExternalMissingPort", func(t *testing.T) {
+		externalPorts = `{"mgmtSSL": 30191}`
+		defer func() { externalPorts = `{"mgmt": 30091}` }()
+		err := addCluster(`"network": "external"`)
+		var httpErr *echo.HTTPError
+		require.ErrorAs(t, err, &httpErr)
+		require.Equal(t, http.StatusBadRequest, httpErr.Code)
+		require.Contains(t, httpErr.Message, "no mgmt port")
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		err := addCluster(`"network": "internal"`)
+		var httpErr *echo.HTTPError
+		require.ErrorAs(t, err, &httpErr)
+		require.Equal(t, http.StatusBadRequest, httpErr.Code)
+	})
+}
+
+func TestPostClustersAddConnectionString(t *testing.T) {
+	promCfgPath, testCluster := setupForTest(t, cbrest.TestClusterOptions{
+		UUID: testClusterUUID,
+		Handlers: map[string]http.HandlerFunc{
+			"GET:/pools/default": func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				_ = json.NewEncoder(w).Encode(&couchbase.PoolsDefault{
+					ClusterName: "Test Cluster",
+					Nodes: []couchbase.Node{
+						{
+							Hostname: "test:8091",
+							Version:  cbvalue.Version7_0_0,
+						},
+					},
+				})
+			},
+		},
+	})
+	defer testCluster.Close()
+
+	addCluster := func(connStr string) error {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/clusters/add", bytes.NewReader([]byte(fmt.Sprintf(`{
+			"hostname": "%s",
+			"couchbaseConfig": {
+				"username": "Administrator",
+				"password": "asdasd",
+				"managementPort": %d
+			}
+		}`, connStr, testCluster.Port()))))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		h := &Server{
+			baseLogger: zap.NewNop(),
+			logger:     zap.NewNop(),
+			echo:       e,
+			production: true,
+			opts: Options{
+				Discovery: couchbase.ClientOptions{MaxAttempts: 1},
+			},
+		}
+		return h.PostClustersAdd(e.NewContext(req, rec), v1.PostClustersAddParams{})
+	}
+
+	t.Run("FirstSeedDown", func(t *testing.T) {
+		// The test cluster only listens on 127.0.0.1, so it can only be discovered from the second seed
+		require.NoError(t, addCluster("couchbase://127.0.0.2,localhost"))
+
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Equal(t, basePromConfig+fmt.Sprintf("    # CMOS managed: 6d3e2b8a "+
+			"connectionString=couchbase:%%2F%%2F127.0.0.2%%2Clocalhost seed=localhost:%[1]d "+
+			"seeds=127.0.0.2:%[1]d%%2Clocalhost:%[1]d\n", testCluster.Port())+`    - job_name: couchbase-server-managed-6d3e2b8a
+      metrics_path: /metrics
+      basic_auth:
+        username: Administrator
+        password: asdasd
+      static_configs:
+        - targets:
+            - test:8091
+          labels:
+            cluster_name: Test Cluster
+            cluster_uuid: 6d3e2b8a
+`, string(result))
+	})
+
+	t.Run("AllSeedsDown", func(t *testing.T) {
+		err := addCluster("couchbase://127.0.0.2,127.0.0.3")
+		var cbErr *couchbase.Error
+		require.ErrorAs(t, err, &cbErr)
+		require.Equal(t, couchbase.ErrorKindConnect, cbErr.Kind)
+	})
+
+	t.Run("DataServicePort", func(t *testing.T) {
+		// The port is the one the SDKs connect to, so the cluster is contacted on its management port instead
+		require.NoError(t, addCluster("couchbase://localhost:11210"))
+
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Contains(t, string(result), fmt.Sprintf(" seed=localhost:%d\n", testCluster.Port()))
+	})
+
+	for name, connStr := range map[string]string{
+		"Invalid":   "couchbase://",
+		"OtherPort": "couchbase://localhost:12000",
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := addCluster(connStr)
+			var httpErr *echo.HTTPError
+			require.ErrorAs(t, err, &httpErr)
+			require.Equal(t, http.StatusBadRequest, httpErr.Code)
+		})
+	}
+}
+
+func TestClusterSeeds(t *testing.T) {
+	t.Run("TLSConnectionString", func(t *testing.T) {
+		// The scheme of the connection string decides the default management port
+		var cbConfig v1.CouchbaseConfig
+		seeds, err := clusterSeeds(context.Background(), "couchbases://10.0.0.1,10.0.0.2:11207", &cbConfig)
+		require.NoError(t, err)
+		require.Equal(t, []string{"10.0.0.1:18091", "10.0.0.2:18091"}, seeds)
+		require.NotNil(t, cbConfig.UseTLS)
+		require.True(t, *cbConfig.UseTLS)
+	})
+
+	t.Run("TLSHostname", func(t *testing.T) {
+		useTLS := true
+		seeds, err := clusterSeeds(context.Background(), "10.0.0.1", &v1.CouchbaseConfig{UseTLS: &useTLS})
+		require.NoError(t, err)
+		require.Equal(t, []string{"10.0.0.1:18091"}, seeds)
+	})
+}
+
+func TestPostClustersAddIPv6(t *testing.T) {
+	promCfgPath := setupForSGWTest(t)
+	// The test cluster only listens on IPv4, so serve the cluster from the IPv6 loopback address ourselves
+	listener, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 is not available: %v", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/pools":
+			_, _ = w.Write([]byte(`{"uuid": "6d3e2b8a"}`))
+		case "/pools/default":
+			_, _ = w.Write([]byte(`{
+				"clusterName": "Test Cluster",
+				"nodes": [
+					{"hostname": "[fd00::1]:8091", "version": "7.0.0"},
+					{
+						"hostname": "[fd00::2]:8091",
+						"version": "6.6.0",
+						"alternateAddresses": {"external": {"hostname": "2001:db8::2", "ports": {"mgmt": 30091}}}
+					}
+				]
+			}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	server.Listener.Close()
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/clusters/add", bytes.NewReader([]byte(fmt.Sprintf(`{
+		"hostname": "[::1]",
+		"couchbaseConfig": {
+			"username": "Administrator",
+			"password": "asdasd",
+			"managementPort": %d
+		}
+	}`, port))))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h := &Server{
+		baseLogger: zap.NewNop(),
+		logger:     zap.NewNop(),
+		echo:       e,
+		production: true,
+	}
+	require.NoError(t, h.PostClustersAdd(e.NewContext(req, rec), v1.PostClustersAddParams{}))
+
+	result, err := os.ReadFile(promCfgPath)
+	require.NoError(t, err)
+	// Annotations are escaped like a query string
+	require.Equal(t, basePromConfig+fmt.Sprintf(`    # CMOS managed: 6d3e2b8a seed=%%5B::1%%5D:%d
+    - job_name: couchbase-server-managed-6d3e2b8a
+      metrics_path: /metrics
+      basic_auth:
+        username: Administrator
+        password: asdasd
+      static_configs:
+        - targets:
+            - '[fd00::1]:8091'
+          labels:
+            cluster_name: Test Cluster
+            cluster_uuid: 6d3e2b8a
+    # CMOS managed: 6d3e2b8a part=exporter
+    - job_name: couchbase-server-managed-6d3e2b8a-exporter
+      metrics_path: /metrics
+      static_configs:
+        - targets:
+            - '[fd00::2]:9091'
+          labels:
+            cluster_name: Test Cluster
+            cluster_uuid: 6d3e2b8a
+`, port), string(result))
+}
+
+func TestPostSgwAdd(t *testing.T) {
+	t.Run("CreateConfig", func(t *testing.T) {
+		promCfgPath := setupForSGWTest(t)
+
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/sgw/add", bytes.NewReader([]byte(fmt.Sprintf(`{
+			"hostname": "%s",
+			"sgwConfig": {
+				"username": "Administrator",
+				"password": "asdasd"
+			}
+		}`, "test"))))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+		h := &Server{
+			baseLogger: zap.NewNop(),
+			logger:     zap.NewNop(),
+			echo:       e,
+			production: true,
+		}
+
+		err := h.PostSgwAdd(ctx, v1.PostSgwAddParams{})
+		require.NoError(t, err)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Equal(t, basePromConfig+`    # CMOS managed: test
+    - job_name: sync-gateway-managed-test
+      metrics_path: /_metrics
+      basic_auth:
+        username: Administrator
+        password: asdasd
+      static_configs:
+        - targets:
+            - test:4986
+          labels:
+            sgw_cluster: test
+`, string(result))
+	})
+
+	t.Run("DryRun", func(t *testing.T) {
+		promCfgPath := setupForSGWTest(t)
+
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/sgw/add?dryRun=true", bytes.NewReader([]byte(`{
+			"hostname": "test",
+			"sgwConfig": {
+				"username": "Administrator",
+				"password": "asdasd"
+			}
+		}`)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+		h := &Server{
+			baseLogger: zap.NewNop(),
+			logger:     zap.NewNop(),
+			echo:       e,
+			production: true,
+		}
+
+		dryRun := true
+		err := h.PostSgwAdd(ctx, v1.PostSgwAddParams{DryRun: &dryRun})
+		require.NoError(t, err)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.NotContains(t, rec.Body.String(), "asdasd")
+		var response struct {
+			Preview v1.ConfigPreview `json:"preview"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		require.Equal(t, `job_name: sync-gateway-managed-test
+metrics_path: /_metrics
+basic_auth:
+    username: Administrator
+    password: <redacted>
+static_configs:
+    - targets:
+        - test:4986
+      labels:
+        sgw_cluster: test
+`, response.Preview.ScrapeConfig)
+		require.Equal(t, fmt.Sprintf(`--- %[1]s
++++ %[1]s
+@@ -13,3 +13,14 @@
+       file_sd_configs:
+         - files:
+             - couchbase/custom/*.json
++    # CMOS managed: test
++    - job_name: sync-gateway-managed-test
++      metrics_path: /_metrics
++      basic_auth:
++        username: Administrator
++        password: <redacted>
++      static_configs:
++        - targets:
++            - test:4986
++          labels:
++            sgw_cluster: test
+`, promCfgPath), response.Preview.Diff)
+
+		// Nothing is written
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Equal(t, basePromConfig, string(result))
+	})
+
+	t.Run("CreateConfigWithName", func(t *testing.T) {
+		promCfgPath := setupForSGWTest(t)
+
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/sgw/add", bytes.NewReader([]byte(fmt.Sprintf(`{
+			"name": "Mobile",
+			"hostname": "%s",
+			"sgwConfig": {
+				"username": "Administrator",
+				"password": "asdasd"
+			}
+		}`, "test"))))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+		h := &Server{
+			baseLogger: zap.NewNop(),
+			logger:     zap.NewNop(),
+			echo:       e,
+			production: true,
+		}
+
+		err := h.PostSgwAdd(ctx, v1.PostSgwAddParams{})
+		require.NoError(t, err)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Equal(t, basePromConfig+`    # CMOS managed: test
+    - job_name: sync-gateway-managed-mobile
+      metrics_path: /_metrics
+      basic_auth:
+        username: Administrator
+        password: asdasd
+      static_configs:
+        - targets:
+            - test:4986
+          labels:
+            cluster_name: Mobile
+            sgw_cluster: Mobile
+`, string(result))
+	})
+}
+
+func TestPrometheusReload(t *testing.T) {
+	addSGW := func(t *testing.T, h *Server) error {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/sgw/add", bytes.NewReader([]byte(`{
+			"hostname": "test",
+			"sgwConfig": {
+				"username": "Administrator",
+				"password": "asdasd"
+			}
+		}`)))
+		req.Header.Set("Content-Type", "application/json")
+		return h.PostSgwAdd(h.echo.NewContext(req, httptest.NewRecorder()), v1.PostSgwAddParams{})
+	}
+
+	t.Run("Reloaded", func(t *testing.T) {
+		promCfgPath := setupForSGWTest(t)
+		fakePrometheus := prometheustest.NewServer(t, promCfgPath)
+		h := &Server{
+			baseLogger: zap.NewNop(),
+			logger:     zap.NewNop(),
+			echo:       echo.New(),
+			production: true,
+			opts:       Options{PrometheusURL: fakePrometheus.URL},
+		}
+
+		// The base config's file service discovery job is reported with an absolute path once loaded
+		require.NoError(t, addSGW(t, h))
+		require.Equal(t, 1, fakePrometheus.Reloads())
+		require.Contains(t, fakePrometheus.Loaded(), "job_name: sync-gateway-managed-test")
+	})
+
+	t.Run("ReloadFailed", func(t *testing.T) {
+		promCfgPath := setupForSGWTest(t)
+		fakePrometheus := prometheustest.NewServer(t, promCfgPath)
+		fakePrometheus.FailReloads("out of cheese")
+		h := &Server{
+			baseLogger: zap.NewNop(),
+			logger:     zap.NewNop(),
+			echo:       echo.New(),
+			production: true,
+			opts:       Options{PrometheusURL: fakePrometheus.URL},
+		}
+
+		err := addSGW(t, h)
+		var httpErr *echo.HTTPError
+		require.ErrorAs(t, err, &httpErr)
+		require.Equal(t, http.StatusBadGateway, httpErr.Code)
+		require.Contains(t, httpErr.Message, "out of cheese")
+
+		// The previous config is put back, and Prometheus asked to load it again
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Equal(t, basePromConfig, string(result))
+		require.Equal(t, 2, fakePrometheus.Reloads())
+	})
+
+	t.Run("RejectedByPromtool", func(t *testing.T) {
+		promCfgPath := setupForSGWTest(t)
+		fakePrometheus := prometheustest.NewServer(t, promCfgPath)
+		promtool := filepath.Join(t.TempDir(), "promtool")
+		require.NoError(t, os.WriteFile(promtool, []byte("#!/bin/sh\necho \"FAILED: $3 is no good\"\nexit 1\n"),
+			0o755))
+		h := &Server{
+			baseLogger: zap.NewNop(),
+			logger:     zap.NewNop(),
+			echo:       echo.New(),
+			production: true,
+			opts:       Options{PrometheusURL: fakePrometheus.URL, PromtoolPath: promtool},
+		}
+
+		err := addSGW(t, h)
+		var httpErr *echo.HTTPError
+		require.ErrorAs(t, err, &httpErr)
+		require.Equal(t, http.StatusUnprocessableEntity, httpErr.Code)
+		require.Contains(t, httpErr.Message, "is no good")
+
+		// Neither the file nor Prometheus are touched
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Equal(t, basePromConfig, string(result))
+		require.Equal(t, 0, fakePrometheus.Reloads())
+		leftovers, err := filepath.Glob(filepath.Join(filepath.Dir(promCfgPath), ".prometheus.yml.check*"))
+		require.NoError(t, err)
+		require.Empty(t, leftovers)
+	})
+
+	t.Run("NotReloadedInHTTPSDMode", func(t *testing.T) {
+		promCfgPath := setupForSGWTest(t)
+		fakePrometheus := prometheustest.NewServer(t, promCfgPath)
+		h := &Server{
+			baseLogger: zap.NewNop(),
+			logger:     zap.NewNop(),
+			echo:       echo.New(),
+			production: true,
+			opts: Options{
+				TargetMode:        TargetModeHTTPSD,
+				ManagedConfigPath: filepath.Join(t.TempDir(), "managed.yml"),
+				PrometheusURL:     fakePrometheus.URL,
+			},
+		}
+
+		require.NoError(t, addSGW(t, h))
+		require.Equal(t, 0, fakePrometheus.Reloads())
+	})
+}
+
+func TestManagedJobName(t *testing.T) {
+	name := func(name string) *string {
+		return &name
+	}
+	cfg := &prometheus.Configuration{
+		ScrapeConfigs: []*prometheus.ScrapeConfig{
+			{JobName: "custom"},
+			{ID: "s1", JobName: sgwJobPrefix + "prod"},
+			{
+				ID:       "c1",
+				JobName:  serverJobPrefix + "foo",
+				Exporter: &prometheus.ScrapeConfig{JobName: serverJobPrefix + "foo" + exporterJobSuffix},
+			},
+			{ID: "c2", JobName: serverJobPrefix + "bar-exporter"},
+		},
+	}
+
+	t.Run("Named", func(t *testing.T) {
+		require.Equal(t, serverJobPrefix+"baz", managedJobName(cfg, serverJobPrefix, name("Baz"), "c3"))
+	})
+
+	t.Run("Unnamed", func(t *testing.T) {
+		require.Equal(t, serverJobPrefix+"c3", managedJobName(cfg, serverJobPrefix, nil, "c3"))
+	})
+
+	t.Run("SameID", func(t *testing.T) {
+		require.Equal(t, serverJobPrefix+"foo", managedJobName(cfg, serverJobPrefix, name("foo"), "c1"))
+	})
+
+	t.Run("NamedCollision", func(t *testing.T) {
+		require.Equal(t, serverJobPrefix+"foo-c3", managedJobName(cfg, serverJobPrefix, name("Foo"), "c3"))
+	})
+
+	t.Run("UnnamedCollision", func(t *testing.T) {
+		require.Equal(t, sgwJobPrefix+"prod-2", managedJobName(cfg, sgwJobPrefix, nil, "prod"))
+	})
+
+	t.Run("ExporterCollision", func(t *testing.T) {
+		require.Equal(t, serverJobPrefix+"foo-exporter-c3",
+			managedJobName(cfg, serverJobPrefix, name("foo exporter"), "c3"))
+		require.Equal(t, serverJobPrefix+"bar-c3", managedJobName(cfg, serverJobPrefix, name("bar"), "c3"))
+	})
+}
+
+func TestConcurrentAdds(t *testing.T) {
+	promCfgPath := setupForSGWTest(t)
+	// Two servers share nothing but the file lock, like two processes would
+	servers := []*Server{
+		{baseLogger: zap.NewNop(), logger: zap.NewNop(), echo: echo.New(), production: true},
+		{baseLogger: zap.NewNop(), logger: zap.NewNop(), echo: echo.New(), production: true},
+	}
+
+	const adds = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, adds)
+	for i := 0; i < adds; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			h := servers[i%len(servers)]
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/sgw/add", bytes.NewReader([]byte(fmt.Sprintf(`{
+				"hostname": "test%d",
+				"sgwConfig": {
+					"username": "Administrator",
+					"password": "asdasd"
+				}
+			}`, i))))
+			req.Header.Set("Content-Type", "application/json")
+			errs <- h.PostSgwAdd(h.echo.NewContext(req, httptest.NewRecorder()), v1.PostSgwAddParams{})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	result, err := os.ReadFile(promCfgPath)
+	require.NoError(t, err)
+	var cfg prometheus.Configuration
+	require.NoError(t, yaml.Unmarshal(result, &cfg))
+	jobs := make(map[string]bool)
+	for _, sc := range cfg.ScrapeConfigs {
+		jobs[sc.JobName] = true
+	}
+	require.Len(t, cfg.ScrapeConfigs, adds)
+	require.Len(t, jobs, adds)
+
+	tmpFiles, err := filepath.Glob(filepath.Join(filepath.Dir(promCfgPath), ".prometheus.yml.tmp*"))
+	require.NoError(t, err)
+	require.Empty(t, tmpFiles)
+}
+
+func TestGetClusters(t *testing.T) {
+	promCfgPath := setupForSGWTest(t)
+	require.NoError(t, os.WriteFile(promCfgPath, []byte(basePromConfig+managedPromConfig), 0o666))
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/clusters", nil)
+	rec := httptest.NewRecorder()
+	ctx := e.NewContext(req, rec)
+	h := &Server{
+		baseLogger: zap.NewNop(),
+		logger:     zap.NewNop(),
+		echo:       e,
+		production: true,
+	}
+
+	err := h.GetClusters(ctx)
+	require.NoError(t, err)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NotContains(t, rec.Body.String(), "asdasd")
+	require.JSONEq(t, `{
+		"clusters": [
+			{
+				"id": "couchbase-server-managed-1",
+				"name": "Test Cluster",
+				"kind": "couchbase-server",
+				"targets": ["test1:18091", "test2:18091"],
+				"metricsPath": "/metrics",
+				"useTLS": true
+			},
+			{
+				"id": "sync-gateway-managed-2",
+				"kind": "sync-gateway",
+				"targets": ["test:4986"],
+				"metricsPath": "/_metrics",
+				"useTLS": false
+			}
+		]
+	}`, rec.Body.String())
+}
+
+func TestPutClustersId(t *testing.T) {
+	const newPassword = "newpassword"
+	var promCfgPath string
+	// onDiscovery is called while the cluster is being contacted, if set
+	var onDiscovery func()
+	testCluster := cbrest.NewTestCluster(t, cbrest.TestClusterOptions{
+		UUID: testClusterUUID,
+		Handlers: map[string]http.HandlerFunc{
+			"GET:/pools/default": func(w http.ResponseWriter, r *http.Request) {
+				if onDiscovery != nil {
+					onDiscovery()
+				}
+				if _, password, _ := r.BasicAuth(); password != newPassword {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+				_ = json.NewEncoder(w).Encode(&couchbase.PoolsDefault{
+					ClusterName: "Test Cluster",
+					Nodes: []couchbase.Node{
+						{
+							Hostname: r.Host,
+							Version:  cbvalue.Version7_0_0,
+						},
+					},
+				})
+			},
+		},
+	})
+	defer testCluster.Close()
+
+	target := fmt.Sprintf("%s:%d", testCluster.Hostname(), testCluster.Port())
+	existingConfig := basePromConfig + fmt.Sprintf(`    # CMOS managed: 6d3e2b8a
+    - job_name: couchbase-server-managed-prod
+      metrics_path: /metrics
+      basic_auth:
+        username: Administrator
+        password: oldpassword
+      static_configs:
+        - targets:
+            - %s
+          labels:
+            cluster_name: Prod
+`, target)
+
+	// omitPort leaves the management port out of the request
+	omitPort := false
+	putCluster := func(t *testing.T, id, password string) error {
+		promCfgPath = setupForSGWTest(t)
+		require.NoError(t, os.WriteFile(promCfgPath, []byte(existingConfig), 0o666))
+
+		portField := fmt.Sprintf(`, "managementPort": %d`, testCluster.Port())
+		if omitPort {
+			portField = ""
+		}
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/clusters/"+id, bytes.NewReader([]byte(fmt.Sprintf(`{
+			"couchbaseConfig": {
+				"username": "Administrator",
+				"password": "%s"%s
+			}
+		}`, password, portField))))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+		h := &Server{
+			baseLogger: zap.NewNop(),
+			logger:     zap.NewNop(),
+			echo:       e,
+			production: true,
+		}
+
+		err := h.PutClustersId(ctx, id, v1.PutClustersIdParams{})
+		if err == nil {
+			require.Equal(t, http.StatusOK, rec.Code)
+		}
+		return err
+	}
+
+	t.Run("UpdateCredentials", func(t *testing.T) {
+		require.NoError(t, putCluster(t, testClusterUUID, newPassword))
+
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Equal(t, basePromConfig+fmt.Sprintf(`    # CMOS managed: 6d3e2b8a seed=%[1]s
+    - job_name: couchbase-server-managed-prod
+      metrics_path: /metrics
+      basic_auth:
+        username: Administrator
+        password: newpassword
+      static_configs:
+        - targets:
+            - %[1]s
+          labels:
+            cluster_name: Prod
+            cluster_uuid: 6d3e2b8a
+`, target), string(result))
+	})
+
+	t.Run("KeepManagementPort", func(t *testing.T) {
+		// The cluster is only reachable on the port it was added with, rather than the default one
+		omitPort = true
+		defer func() { omitPort = false }()
+		require.NoError(t, putCluster(t, testClusterUUID, newPassword))
+
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Contains(t, string(result), "seed="+target)
+	})
+
+	t.Run("ChangedDuringDiscovery", func(t *testing.T) {
+		changedConfig := existingConfig + "    - job_name: added-meanwhile\n"
+		onDiscovery = func() {
+			require.NoError(t, os.WriteFile(promCfgPath, []byte(changedConfig), 0o666))
+		}
+		defer func() { onDiscovery = nil }()
+
+		err := putCluster(t, testClusterUUID, newPassword)
+		var httpErr *echo.HTTPError
+		require.ErrorAs(t, err, &httpErr)
+		require.Equal(t, http.StatusPreconditionFailed, httpErr.Code)
+
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Equal(t, changedConfig, string(result))
+	})
+
+	t.Run("InvalidCredentials", func(t *testing.T) {
+		require.Error(t, putCluster(t, testClusterUUID, "wrongpassword"))
+
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Equal(t, existingConfig, string(result))
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		err := putCluster(t, "unknown", newPassword)
+		var httpErr *echo.HTTPError
+		require.ErrorAs(t, err, &httpErr)
+		require.Equal(t, http.StatusNotFound, httpErr.Code)
+	})
+}
+
+func TestDeleteClustersId(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		id       string
+		expected string
+	}{
+		{
+			name: "Server",
+			id:   "couchbase-server-managed-1",
+			expected: basePromConfig + `    # CMOS managed
+    - job_name: sync-gateway-managed-2
+      metrics_path: /_metrics
+      basic_auth:
+        username: Administrator
+        password: asdasd
+      static_configs:
+        - targets:
+            - test:4986
+          labels: {}
+`,
+		},
+		{
+			name: "SyncGateway",
+			id:   "sync-gateway-managed-2",
+			expected: basePromConfig + `    # CMOS managed
+    - job_name: couchbase-server-managed-1
+      metrics_path: /metrics
+      scheme: https
+      basic_auth:
+        username: Administrator
+        password: asdasd
+      static_configs:
+        - targets:
+            - test1:18091
+            - test2:18091
+          labels:
+            cluster_name: Test Cluster
+`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			promCfgPath := setupForSGWTest(t)
+			require.NoError(t, os.WriteFile(promCfgPath, []byte(basePromConfig+managedPromConfig), 0o666))
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodDelete, "/api/v1/clusters/"+tc.id, nil)
+			rec := httptest.NewRecorder()
+			ctx := e.NewContext(req, rec)
+			h := &Server{
+				baseLogger: zap.NewNop(),
+				logger:     zap.NewNop(),
+				echo:       e,
+				production: true,
+			}
+
+			err := h.DeleteClustersId(ctx, tc.id, v1.DeleteClustersIdParams{})
+			require.NoError(t, err)
+
+			require.Equal(t, http.StatusOK, rec.Code)
+
+			result, err := os.ReadFile(promCfgPath)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, string(result))
+		})
+	}
+
+	t.Run("NotFound", func(t *testing.T) {
+		promCfgPath := setupForSGWTest(t)
+		require.NoError(t, os.WriteFile(promCfgPath, []byte(basePromConfig+managedPromConfig), 0o666))
+
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodDelete, "/api/v1/clusters/test", nil)
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+		h := &Server{
+			baseLogger: zap.NewNop(),
+			logger:     zap.NewNop(),
+			echo:       e,
+			production: true,
+		}
+
+		// "test" is the name of a user-written job, which must not be removable
+		err := h.DeleteClustersId(ctx, "test", v1.DeleteClustersIdParams{})
+		var httpErr *echo.HTTPError
+		require.ErrorAs(t, err, &httpErr)
+		require.Equal(t, http.StatusNotFound, httpErr.Code)
+
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Equal(t, basePromConfig+managedPromConfig, string(result))
+	})
+}
+
+func TestIfMatch(t *testing.T) {
+	promCfgPath := setupForSGWTest(t)
+	require.NoError(t, os.WriteFile(promCfgPath, []byte(basePromConfig+managedPromConfig), 0o666))
+
+	e := echo.New()
+	h := &Server{
+		baseLogger: zap.NewNop(),
+		logger:     zap.NewNop(),
+		echo:       e,
+		production: true,
+	}
+	v1.RegisterHandlers(e, h)
+
+	do := func(method, path, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodGet, "/clusters", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	etag := rec.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	t.Run("Stale", func(t *testing.T) {
+		rec := do(http.MethodDelete, "/clusters/couchbase-server-managed-1", `"0123456789abcdef"`)
+		require.Equal(t, http.StatusPreconditionFailed, rec.Code)
+
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Equal(t, basePromConfig+managedPromConfig, string(result))
+	})
+
+	t.Run("Current", func(t *testing.T) {
+		rec := do(http.MethodDelete, "/clusters/couchbase-server-managed-1", etag)
+		require.Equal(t, http.StatusOK, rec.Code)
+		newETag := rec.Header().Get("ETag")
+		require.NotEqual(t, etag, newETag)
+
+		// The ETag returned by a change is the one a GET would now return
+		rec = do(http.MethodGet, "/clusters", "")
+		require.Equal(t, newETag, rec.Header().Get("ETag"))
+	})
+
+	t.Run("ChangedSinceRead", func(t *testing.T) {
+		// The first ETag is out of date now that a cluster has been removed
+		rec := do(http.MethodDelete, "/clusters/sync-gateway-managed-2", etag)
+		require.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	})
+
+	t.Run("Any", func(t *testing.T) {
+		rec := do(http.MethodDelete, "/clusters/sync-gateway-managed-2", "*")
+		require.Equal(t, http.StatusOK, rec.Code)
 	})
 }
 
@@ -0,0 +1,213 @@
+// Copyright 2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+
+	v1 "github.com/couchbaselabs/observability/config-svc/pkg/api/v1"
+	"github.com/couchbaselabs/observability/config-svc/pkg/couchbase"
+	"github.com/couchbaselabs/observability/config-svc/pkg/prometheus"
+	"github.com/labstack/echo/v4"
+)
+
+func (s *Server) GetSdCouchbase(ctx echo.Context) error {
+	return s.serveTargetGroups(ctx, v1.ManagedClusterKindCouchbaseServer)
+}
+
+func (s *Server) GetSdSyncGateway(ctx echo.Context) error {
+	return s.serveTargetGroups(ctx, v1.ManagedClusterKindSyncGateway)
+}
+
+// serveTargetGroups responds with the targets of every managed cluster of the given kind. Outside of HTTP SD mode
+// Prometheus already learns of the managed clusters another way, so no targets are returned, to avoid scraping them
+// twice.
+func (s *Server) serveTargetGroups(ctx echo.Context, kind v1.ManagedClusterKind) error {
+	groups := make([]prometheus.TargetGroup, 0)
+	if s.opts.TargetMode != TargetModeHTTPSD {
+		return ctx.JSON(http.StatusOK, groups)
+	}
+
+	cfgFile, cfg, err := s.openManagedConfig()
+	if err != nil {
+		return err
+	}
+	cfgFile.Close()
+
+	for _, sc := range cfg.ScrapeConfigs {
+		managed := managedClusterFromScrapeConfig(sc)
+		if managed.Kind != kind {
+			continue
+		}
+		groups = append(groups, s.targetGroupsForScrapeConfig(sc)...)
+	}
+	return ctx.JSON(http.StatusOK, groups)
+}
+
+// targetGroupsForScrapeConfig converts a managed scrape config, and its exporter job if it has one, into target groups.
+func (s *Server) targetGroupsForScrapeConfig(sc *prometheus.ScrapeConfig) []prometheus.TargetGroup {
+	var groups []prometheus.TargetGroup
+	for _, job := range scrapeJobs(sc) {
+		groups = append(groups, s.targetGroupsForJob(sc.ID, job)...)
+	}
+	return groups
+}
+
+// targetGroupsForJob converts a single job into target groups. Settings that would otherwise be part of the job are
+// carried by the reserved labels, and the job label keeps the series of each cluster apart. Clusters that use service
+// discovery themselves get the targets they last reported, see discoverTargets.
+func (s *Server) targetGroupsForJob(id string, sc *prometheus.ScrapeConfig) []prometheus.TargetGroup {
+	scheme := sc.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	common := map[string]string{
+		"job":              sc.JobName,
+		"__metrics_path__": sc.MetricsPath,
+		"__scheme__":       scheme,
+	}
+	for _, relabel := range sc.RelabelConfigs {
+		if len(relabel.SourceLabels) == 0 && relabel.TargetLabel != "" {
+			common[relabel.TargetLabel] = relabel.Replacement
+		}
+	}
+
+	groups := make([]prometheus.TargetGroup, 0, len(sc.StaticConfigs))
+	for _, static := range sc.StaticConfigs {
+		groups = append(groups, prometheus.TargetGroup{
+			Targets: static.Targets,
+			Labels:  mergeLabels(static.Labels, common),
+		})
+	}
+	if len(sc.HTTPSDConfigs) > 0 {
+		// Each target is a group of its own, as the relabel configs matching its address describe its node
+		for _, group := range s.getDiscoveredTargets(id) {
+			for _, target := range group.Targets {
+				groups = append(groups, prometheus.TargetGroup{
+					Targets: []string{target},
+					Labels:  mergeLabels(mergeLabels(group.Labels, common), addressLabels(sc, target)),
+				})
+			}
+		}
+	}
+	return groups
+}
+
+// addressLabels returns the labels the relabel configs of sc give a target by matching its address.
+func addressLabels(sc *prometheus.ScrapeConfig, target string) map[string]string {
+	labels := make(map[string]string)
+	for _, relabel := range sc.RelabelConfigs {
+		if len(relabel.SourceLabels) != 1 || relabel.SourceLabels[0] != addressLabel || relabel.TargetLabel == "" {
+			continue
+		}
+		// Prometheus anchors the regex at both ends
+		re, err := regexp.Compile("^(?:" + relabel.Regex + ")$")
+		if err != nil || !re.MatchString(target) {
+			continue
+		}
+		labels[relabel.TargetLabel] = relabel.Replacement
+	}
+	return labels
+}
+
+// discoverTargets fetches the targets of a cluster that uses service discovery itself, and keeps them until they are
+// next fetched. Prometheus asks for the targets far more often than they change, so it is never kept waiting on the
+// clusters. Nothing is fetched when Prometheus scrapes the clusters itself.
+func (s *Server) discoverTargets(ctx context.Context, client *couchbase.Client, id string,
+	sc *prometheus.ScrapeConfig) error {
+	if s.opts.TargetMode != TargetModeHTTPSD && s.opts.TargetMode != TargetModeFileSD {
+		return nil
+	}
+	if len(sc.HTTPSDConfigs) == 0 {
+		s.forgetDiscoveredTargets(id)
+		return nil
+	}
+
+	var discovered []prometheus.TargetGroup
+	for _, sdConfig := range sc.HTTPSDConfigs {
+		username, password, err := sdConfig.HTTPClientConfig.BasicAuth.Credentials()
+		if err != nil {
+			return err
+		}
+		groups, err := client.FetchPrometheusTargets(ctx, sdConfig.URL, username, password)
+		if err != nil {
+			return err
+		}
+		discovered = append(discovered, groups...)
+	}
+
+	s.discoveredMu.Lock()
+	defer s.discoveredMu.Unlock()
+	if s.discoveredTargets == nil {
+		s.discoveredTargets = make(map[string][]prometheus.TargetGroup)
+	}
+	s.discoveredTargets[id] = discovered
+	return nil
+}
+
+// discoverAllTargets fetches the targets of every managed cluster that uses service discovery itself, without checking
+// the rest of their topology. With the reconciler turned off, this is the only way they become known after a restart.
+func (s *Server) discoverAllTargets() error {
+	if s.opts.TargetMode != TargetModeHTTPSD && s.opts.TargetMode != TargetModeFileSD {
+		return nil
+	}
+	cfgFile, cfg, err := s.openManagedConfig()
+	if err != nil {
+		return err
+	}
+	cfgFile.Close()
+
+	for _, sc := range cfg.ScrapeConfigs {
+		managed := managedClusterFromScrapeConfig(sc)
+		if managed.Kind != v1.ManagedClusterKindCouchbaseServer || len(sc.HTTPSDConfigs) == 0 {
+			continue
+		}
+		tlsConfig, err := sc.HTTPClientConfig.TLSConfig.ClientConfig()
+		if err == nil {
+			err = s.discoverTargets(context.Background(), s.couchbaseClient(tlsConfig), sc.ID, sc)
+		}
+		if err != nil {
+			s.logger.Sugar().Warnw("Failed to get targets of cluster", "id", managed.Id, "err", err)
+		}
+	}
+	return s.writeCurrentTargetFiles()
+}
+
+func (s *Server) forgetDiscoveredTargets(id string) {
+	s.discoveredMu.Lock()
+	defer s.discoveredMu.Unlock()
+	delete(s.discoveredTargets, id)
+}
+
+// getDiscoveredTargets returns the targets the given cluster last reported, or nil if they have not been fetched yet.
+func (s *Server) getDiscoveredTargets(id string) []prometheus.TargetGroup {
+	s.discoveredMu.Lock()
+	defer s.discoveredMu.Unlock()
+	return s.discoveredTargets[id]
+}
+
+// mergeLabels returns a copy of labels with overrides applied on top.
+func mergeLabels(labels, overrides map[string]string) map[string]string {
+	merged := make(map[string]string, len(labels)+len(overrides))
+	for k, v := range labels {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		merged[k] = v
+	}
+	return merged
+}
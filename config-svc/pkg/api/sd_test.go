@@ -0,0 +1,275 @@
+// Copyright 2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/couchbase/tools-common/cbrest"
+	"github.com/couchbase/tools-common/cbvalue"
+	v1 "github.com/couchbaselabs/observability/config-svc/pkg/api/v1"
+	"github.com/couchbaselabs/observability/config-svc/pkg/couchbase"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestGetSdCouchbase(t *testing.T) {
+	testCluster := cbrest.NewTestCluster(t, cbrest.TestClusterOptions{
+		UUID: testClusterUUID,
+		Handlers: map[string]http.HandlerFunc{
+			"GET:/prometheus_sd_config": func(w http.ResponseWriter, r *http.Request) {
+				if username, _, _ := r.BasicAuth(); username != "Administrator" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+				_, _ = w.Write([]byte(`[{"targets": ["test3:8091"], "labels": {"cluster_uuid": "6d3e2b8a"}}]`))
+			},
+		},
+	})
+	defer testCluster.Close()
+
+	managedCfgPath := filepath.Join(t.TempDir(), "managed.yml")
+	sdConfig := fmt.Sprintf(`    # CMOS managed: 6d3e2b8a
+    - job_name: couchbase-server-managed-sd
+      metrics_path: /metrics
+      basic_auth:
+        username: Administrator
+        password: asdasd
+      http_sd_configs:
+        - url: %s/prometheus_sd_config?clusterLabels=uuidOnly
+          basic_auth:
+            username: Administrator
+            password: asdasd
+      relabel_configs:
+        - target_label: cluster_name
+          replacement: SD Cluster
+        - source_labels:
+            - __address__
+          regex: test3:8091
+          target_label: node_uuid
+          replacement: node3
+        - source_labels:
+            - __address__
+          regex: test4:8091
+          target_label: node_uuid
+          replacement: node4
+`, testCluster.URL())
+	require.NoError(t, os.WriteFile(managedCfgPath, []byte("scrape_configs:\n"+managedPromConfig+sdConfig), 0o600))
+
+	t.Run("HTTPSDMode", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/api/v1/sd/couchbase", nil), rec)
+		h := &Server{
+			baseLogger: zap.NewNop(),
+			logger:     zap.NewNop(),
+			echo:       e,
+			production: true,
+			opts:       Options{TargetMode: TargetModeHTTPSD, ManagedConfigPath: managedCfgPath},
+		}
+
+		// The targets of the cluster using service discovery are only known once it has been checked
+		require.NoError(t, h.GetSdCouchbase(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.JSONEq(t, `[
+			{
+				"targets": ["test1:18091", "test2:18091"],
+				"labels": {
+					"job": "couchbase-server-managed-1",
+					"__metrics_path__": "/metrics",
+					"__scheme__": "https",
+					"cluster_name": "Test Cluster"
+				}
+			}
+		]`, rec.Body.String())
+
+		cfgFile, cfg, err := h.openManagedConfig()
+		require.NoError(t, err)
+		cfgFile.Close()
+		sc := cfg.ScrapeConfigs[findManagedScrapeConfig(cfg, "6d3e2b8a")]
+		require.NoError(t, h.discoverTargets(context.Background(), h.couchbaseClient(nil), sc.ID, sc))
+
+		rec = httptest.NewRecorder()
+		ctx = e.NewContext(httptest.NewRequest(http.MethodGet, "/api/v1/sd/couchbase", nil), rec)
+		require.NoError(t, h.GetSdCouchbase(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.JSONEq(t, `[
+			{
+				"targets": ["test1:18091", "test2:18091"],
+				"labels": {
+					"job": "couchbase-server-managed-1",
+					"__metrics_path__": "/metrics",
+					"__scheme__": "https",
+					"cluster_name": "Test Cluster"
+				}
+			},
+			{
+				"targets": ["test3:8091"],
+				"labels": {
+					"job": "couchbase-server-managed-sd",
+					"__metrics_path__": "/metrics",
+					"__scheme__": "http",
+					"cluster_name": "SD Cluster",
+					"cluster_uuid": "6d3e2b8a",
+					"node_uuid": "node3"
+				}
+			}
+		]`, rec.Body.String())
+	})
+
+	t.Run("DiscoveredAtStartup", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/api/v1/sd/couchbase", nil), rec)
+		h := &Server{
+			baseLogger: zap.NewNop(),
+			logger:     zap.NewNop(),
+			echo:       e,
+			production: true,
+			opts:       Options{TargetMode: TargetModeHTTPSD, ManagedConfigPath: managedCfgPath},
+		}
+
+		// As when the reconciler is turned off
+		require.NoError(t, h.discoverAllTargets())
+		require.NoError(t, h.GetSdCouchbase(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Contains(t, rec.Body.String(), `"targets":["test3:8091"]`)
+	})
+
+	t.Run("PrometheusConfigMode", func(t *testing.T) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/api/v1/sd/couchbase", nil), rec)
+		h := &Server{
+			baseLogger: zap.NewNop(),
+			logger:     zap.NewNop(),
+			echo:       e,
+			production: true,
+			opts:       Options{ManagedConfigPath: managedCfgPath},
+		}
+
+		require.NoError(t, h.GetSdCouchbase(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.JSONEq(t, `[]`, rec.Body.String())
+	})
+}
+
+func TestGetSdSyncGateway(t *testing.T) {
+	managedCfgPath := filepath.Join(t.TempDir(), "managed.yml")
+	require.NoError(t, os.WriteFile(managedCfgPath, []byte("scrape_configs:\n"+managedPromConfig), 0o600))
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/api/v1/sd/sync-gateway", nil), rec)
+	h := &Server{
+		baseLogger: zap.NewNop(),
+		logger:     zap.NewNop(),
+		echo:       e,
+		production: true,
+		opts:       Options{TargetMode: TargetModeHTTPSD, ManagedConfigPath: managedCfgPath},
+	}
+
+	require.NoError(t, h.GetSdSyncGateway(ctx))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `[
+		{
+			"targets": ["test:4986"],
+			"labels": {
+				"job": "sync-gateway-managed-2",
+				"__metrics_path__": "/_metrics",
+				"__scheme__": "http"
+			}
+		}
+	]`, rec.Body.String())
+}
+
+func TestPostClustersAddHTTPSDMode(t *testing.T) {
+	promCfgPath, testCluster := setupForTest(t, cbrest.TestClusterOptions{
+		UUID: testClusterUUID,
+		Handlers: map[string]http.HandlerFunc{
+			"GET:/pools/default": func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				_ = json.NewEncoder(w).Encode(&couchbase.PoolsDefault{
+					ClusterName: "Test Cluster",
+					Nodes: []couchbase.Node{
+						{
+							Hostname: "test1:8091",
+							Version:  cbvalue.Version7_0_0,
+						},
+					},
+				})
+			},
+		},
+	})
+	defer testCluster.Close()
+	managedCfgPath := filepath.Join(t.TempDir(), "cmos", "managed.yml")
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/clusters/add", bytes.NewReader([]byte(fmt.Sprintf(`{
+		"hostname": "localhost",
+		"couchbaseConfig": {
+			"username": "Administrator",
+			"password": "asdasd",
+			"managementPort": %d
+		}
+	}`, testCluster.Port()))))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	ctx := e.NewContext(req, httptest.NewRecorder())
+	h := &Server{
+		baseLogger: zap.NewNop(),
+		logger:     zap.NewNop(),
+		echo:       e,
+		production: true,
+		opts:       Options{TargetMode: TargetModeHTTPSD, ManagedConfigPath: managedCfgPath},
+	}
+
+	require.NoError(t, h.PostClustersAdd(ctx, v1.PostClustersAddParams{}))
+
+	// The Prometheus config is left alone...
+	result, err := os.ReadFile(promCfgPath)
+	require.NoError(t, err)
+	require.Equal(t, basePromConfig, string(result))
+
+	// ...and the cluster is kept in the managed config instead, which only we can read.
+	result, err = os.ReadFile(managedCfgPath)
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf(`scrape_configs:
+    # CMOS managed: 6d3e2b8a seed=localhost:%d
+    - job_name: couchbase-server-managed-6d3e2b8a
+      metrics_path: /metrics
+      basic_auth:
+        username: Administrator
+        password: asdasd
+      static_configs:
+        - targets:
+            - test1:8091
+          labels:
+            cluster_name: Test Cluster
+            cluster_uuid: 6d3e2b8a
+`, testCluster.Port()), string(result))
+	info, err := os.Stat(managedCfgPath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
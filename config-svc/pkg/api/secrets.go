@@ -0,0 +1,183 @@
+// Copyright 2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/couchbaselabs/observability/config-svc/pkg/prometheus"
+	"github.com/labstack/echo/v4"
+)
+
+// Suffixes of the files we keep in the secrets directory, so that we never remove anything else.
+const (
+	passwordFileSuffix = ".password"
+	caFileSuffix       = ".ca.pem"
+	certFileSuffix     = ".cert.pem"
+	keyFileSuffix      = ".key.pem"
+)
+
+// unsafeFileNameChars matches the characters of a job name that should not be used in a file name.
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// httpClientConfigs returns the HTTP client settings of a scrape config: its own, and those of its service discovery.
+func httpClientConfigs(sc *prometheus.ScrapeConfig) []*prometheus.HTTPClientConfig {
+	configs := []*prometheus.HTTPClientConfig{&sc.HTTPClientConfig}
+	for i := range sc.HTTPSDConfigs {
+		configs = append(configs, &sc.HTTPSDConfigs[i].HTTPClientConfig)
+	}
+	return configs
+}
+
+// secretFiles returns the files a scrape config and its exporter job refer to in the secrets directory.
+func secretFiles(sc *prometheus.ScrapeConfig) []string {
+	var files []string
+	for _, job := range scrapeJobs(sc) {
+		for _, client := range httpClientConfigs(job) {
+			if client.BasicAuth != nil {
+				files = append(files, client.BasicAuth.PasswordFile)
+			}
+			if client.TLSConfig != nil {
+				files = append(files, client.TLSConfig.CAFile, client.TLSConfig.CertFile, client.TLSConfig.KeyFile)
+			}
+		}
+	}
+	return files
+}
+
+// secretFile returns the path of a file holding a secret of a job.
+func (s *Server) secretFile(jobName, suffix string) string {
+	return filepath.Join(s.opts.SecretsDir, unsafeFileNameChars.ReplaceAllString(jobName, "_")+suffix)
+}
+
+// externalisePasswords replaces the passwords in the managed scrape configs with files in the secrets directory, so
+// that they are kept out of the config and anything it is copied into. It returns the contents of each file, to be
+// written along with the config by writeManagedContents.
+func (s *Server) externalisePasswords(cfg *prometheus.Configuration) map[string][]byte {
+	files := make(map[string][]byte)
+	if s.opts.SecretsDir == "" {
+		return files
+	}
+	for _, sc := range cfg.ScrapeConfigs {
+		// The exporters have credentials of their own, so they are kept under the name of their own job
+		for _, job := range scrapeJobs(sc) {
+			passwordFile := s.secretFile(job.JobName, passwordFileSuffix)
+			for _, client := range httpClientConfigs(job) {
+				auth := client.BasicAuth
+				if auth == nil || auth.Password == "" {
+					continue
+				}
+				files[passwordFile] = []byte(auth.Password)
+				auth.PasswordFile = passwordFile
+				auth.Password = ""
+			}
+		}
+	}
+	return files
+}
+
+// writeSecretFiles writes files to the secrets directory, readable only by us and Prometheus running as the same user.
+// It returns a function that puts the files back as they were, for when the config referring to them is not written
+// after all, as the config in use may refer to the same files.
+func (s *Server) writeSecretFiles(files map[string][]byte) (func(), error) {
+	if len(files) == 0 {
+		return func() {}, nil
+	}
+	if err := os.MkdirAll(s.opts.SecretsDir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create secrets directory: %w", err)
+	}
+
+	// A nil entry is a file that did not exist before
+	previous := make(map[string][]byte, len(files))
+	restore := func() {
+		for path, contents := range previous {
+			var err error
+			if contents == nil {
+				err = os.Remove(path)
+			} else {
+				err = writeFileAtomically(path, contents, 0o600)
+			}
+			if err != nil && !os.IsNotExist(err) {
+				s.logger.Sugar().Warnw("Failed to restore secret file", "path", path, "err", err)
+			}
+		}
+	}
+	for path, contents := range files {
+		existing, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			restore()
+			return nil, fmt.Errorf("failed to read secret file: %w", err)
+		}
+		if existing == nil && err == nil {
+			existing = []byte{}
+		}
+		previous[path] = existing
+		if err := writeFileAtomically(path, contents, 0o600); err != nil {
+			restore()
+			return nil, err
+		}
+	}
+	return restore, nil
+}
+
+// removeUnusedSecretFiles removes the files in the secrets directory that no managed scrape config refers to any
+// more, such as those of clusters that are no longer managed.
+func (s *Server) removeUnusedSecretFiles(cfg *prometheus.Configuration) error {
+	if s.opts.SecretsDir == "" {
+		return nil
+	}
+	used := make(map[string]bool)
+	for _, sc := range cfg.ScrapeConfigs {
+		for _, path := range secretFiles(sc) {
+			used[path] = true
+		}
+	}
+	for _, suffix := range []string{passwordFileSuffix, caFileSuffix, certFileSuffix, keyFileSuffix} {
+		existing, err := filepath.Glob(filepath.Join(s.opts.SecretsDir, "*"+suffix))
+		if err != nil {
+			return fmt.Errorf("failed to list secret files: %w", err)
+		}
+		for _, path := range existing {
+			if used[path] {
+				continue
+			}
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove secret file: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkSecretFiles makes sure the files in the secrets directory a config refers to still exist, as those of clusters
+// that have since been removed are gone and cannot be rolled back to.
+func checkSecretFiles(cfg *prometheus.Configuration) error {
+	for _, sc := range cfg.ScrapeConfigs {
+		for _, path := range secretFiles(sc) {
+			if path == "" {
+				continue
+			}
+			if _, err := os.Stat(path); err != nil {
+				return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("the credentials of %s have been removed "+
+					"since, so it needs to be added again instead", sc.JobName))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1 "github.com/couchbaselabs/observability/config-svc/pkg/api/v1"
+	"github.com/couchbaselabs/observability/config-svc/pkg/prometheus/prometheustest"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPasswordFiles(t *testing.T) {
+	promCfgPath := setupForSGWTest(t)
+	require.NoError(t, os.WriteFile(promCfgPath, []byte(basePromConfig+managedPromConfig), 0o600))
+	secretsDir := filepath.Join(t.TempDir(), "secrets")
+
+	e := echo.New()
+	h := &Server{
+		baseLogger: zap.NewNop(),
+		logger:     zap.NewNop(),
+		echo:       e,
+		production: true,
+		opts: Options{
+			SecretsDir: secretsDir,
+		},
+	}
+	v1.RegisterHandlers(e, h)
+
+	do := func(t *testing.T, method, path, body string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	do(t, http.MethodPost, "/sgw/add", `{
+		"hostname": "test",
+		"sgwConfig": {
+			"username": "Administrator",
+			"password": "new-password"
+		}
+	}`)
+
+	result, err := os.ReadFile(promCfgPath)
+	require.NoError(t, err)
+	require.NotContains(t, string(result), "asdasd")
+	require.NotContains(t, string(result), "new-password")
+	// The clusters added before password files were used have been moved over too
+	for jobName, password := range map[string]string{
+		"couchbase-server-managed-1": "asdasd",
+		"sync-gateway-managed-2":     "asdasd",
+		"sync-gateway-managed-test":  "new-password",
+	} {
+		passwordFile := filepath.Join(secretsDir, jobName+".password")
+		require.Contains(t, string(result), "password_file: "+passwordFile+"\n")
+
+		info, err := os.Stat(passwordFile)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+		contents, err := os.ReadFile(passwordFile)
+		require.NoError(t, err)
+		require.Equal(t, password, string(contents))
+	}
+
+	t.Run("NotLoaded", func(t *testing.T) {
+		fakePrometheus := prometheustest.NewServer(t, promCfgPath)
+		fakePrometheus.FailReloads("out of memory")
+		h.opts.PrometheusURL = fakePrometheus.URL
+		defer func() { h.opts.PrometheusURL = "" }()
+
+		req := httptest.NewRequest(http.MethodPost, "/sgw/add", strings.NewReader(`{
+			"hostname": "test",
+			"sgwConfig": {
+				"username": "Administrator",
+				"password": "another-password"
+			}
+		}`))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusBadGateway, rec.Code, rec.Body.String())
+
+		// The restored config refers to the same file, so it must still have the password that goes with it
+		contents, err := os.ReadFile(filepath.Join(secretsDir, "sync-gateway-managed-test.password"))
+		require.NoError(t, err)
+		require.Equal(t, "new-password", string(contents))
+	})
+
+	t.Run("Removed", func(t *testing.T) {
+		do(t, http.MethodDelete, "/clusters/test", "")
+		require.NoFileExists(t, filepath.Join(secretsDir, "sync-gateway-managed-test.password"))
+		require.FileExists(t, filepath.Join(secretsDir, "sync-gateway-managed-2.password"))
+	})
+}
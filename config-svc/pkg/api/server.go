@@ -15,32 +15,101 @@
 package api
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/brpaz/echozap"
+	v1 "github.com/couchbaselabs/observability/config-svc/pkg/api/v1"
+	"github.com/couchbaselabs/observability/config-svc/pkg/couchbase"
+	"github.com/couchbaselabs/observability/config-svc/pkg/prometheus"
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
 )
 
+// TargetMode is how Prometheus is told about the managed clusters.
+type TargetMode string
+
+const (
+	// TargetModePrometheusConfig writes a scrape config for each managed cluster into the Prometheus config file.
+	TargetModePrometheusConfig TargetMode = "prometheus-config"
+	// TargetModeHTTPSD keeps the managed clusters in a file of our own, and serves their targets to Prometheus through
+	// the HTTP service discovery endpoints. Prometheus scrapes them all with the credentials of its own job, so the
+	// credentials and TLS settings of each cluster are not used.
+	TargetModeHTTPSD TargetMode = "http-sd"
+	// TargetModeFileSD keeps the managed clusters in a file of our own, and writes their targets as file service
+	// discovery files, for when the Prometheus config cannot be changed. As with TargetModeHTTPSD, the credentials and
+	// TLS settings of each cluster are not used.
+	TargetModeFileSD TargetMode = "file-sd"
+)
+
+// Options configures the optional behaviour of a Server.
+type Options struct {
+	// ReconcileInterval is how often the topology of the managed clusters is checked. Zero disables the check, though
+	// the targets of clusters using service discovery themselves are still fetched once at startup.
+	ReconcileInterval time.Duration
+	// TargetMode is how Prometheus is told about the managed clusters. Empty means TargetModePrometheusConfig.
+	TargetMode TargetMode
+	// ManagedConfigPath is where the managed clusters are kept when TargetMode is TargetModeHTTPSD or TargetModeFileSD.
+	ManagedConfigPath string
+	// FileSDDir is the directory the file service discovery files are written to when TargetMode is TargetModeFileSD.
+	FileSDDir string
+	// PrometheusURL is where Prometheus is served, so that it can be told to reload after its config file is changed.
+	// Empty disables the reload.
+	PrometheusURL string
+	// PromtoolPath is the promtool binary used to check the Prometheus config before it is changed. Empty disables the
+	// check.
+	PromtoolPath string
+	// HistoryDir is where the revisions of the managed config are kept, so that changes can be rolled back. Empty
+	// disables the history.
+	HistoryDir string
+	// HistorySize is how many revisions are kept. Zero means 50.
+	HistorySize int
+	// SecretsDir is where the passwords of the managed clusters are kept, so that the config only refers to them.
+	// Empty keeps them in the config.
+	SecretsDir string
+	// Discovery configures the timeouts and retries of requests to the managed clusters.
+	Discovery couchbase.ClientOptions
+}
+
 type Server struct {
 	baseLogger *zap.Logger
 	logger     *zap.Logger
 	echo       *echo.Echo
 	production bool
+	opts       Options
+
+	// configMu serialises changes to the managed config within this process, see openManagedConfig
+	configMu sync.Mutex
+
+	reconcileMu     sync.Mutex
+	reconcileStatus map[string]v1.ReconcileStatus
+
+	// discoveredMu guards the targets last reported by the clusters that use service discovery themselves, by cluster
+	// UUID, see discoverTargets
+	discoveredMu      sync.Mutex
+	discoveredTargets map[string][]prometheus.TargetGroup
 }
 
-func NewServer(baseLogger *zap.Logger, pathPrefix string, production bool) (*Server, error) {
+func NewServer(baseLogger *zap.Logger, pathPrefix string, production bool, opts Options) (*Server, error) {
 	server := Server{
 		baseLogger: baseLogger,
 		logger:     baseLogger.Named("server"),
 		echo:       echo.New(),
 		production: production,
+		opts:       opts,
 	}
 	server.echo.HideBanner = true
 	server.echo.HidePort = true
 	server.echo.Use(echozap.ZapLogger(server.logger))
 	server.echo.HTTPErrorHandler = server.handleError
+	// The config history records who made each change, so only nginx, which runs alongside us, is trusted to say where
+	// a request came from
+	server.echo.IPExtractor = echo.ExtractIPFromRealIPHeader(echo.TrustLinkLocal(false), echo.TrustPrivateNet(false))
 	server.registerRoutes(pathPrefix)
 	return &server, nil
 }
@@ -48,9 +117,12 @@ func NewServer(baseLogger *zap.Logger, pathPrefix string, production bool) (*Ser
 func (s *Server) handleError(err error, ctx echo.Context) {
 	code := http.StatusInternalServerError
 	msg := err.Error()
+	var cbErr *couchbase.Error
 	if httpErr, ok := err.(*echo.HTTPError); ok {
 		code = httpErr.Code
 		msg = fmt.Sprintf("%v", httpErr.Message)
+	} else if errors.As(err, &cbErr) {
+		code = couchbaseErrorStatus(cbErr)
 	}
 	_ = ctx.JSON(code, map[string]interface{}{
 		"ok":  false,
@@ -58,8 +130,26 @@ func (s *Server) handleError(err error, ctx echo.Context) {
 	})
 }
 
+// couchbaseErrorStatus returns the status to respond with when a cluster could not be contacted. Rejected credentials
+// were given to us, while anything else is a problem with the cluster or the way to it.
+func couchbaseErrorStatus(err *couchbase.Error) int {
+	if err.Kind == couchbase.ErrorKindAuth {
+		return http.StatusBadRequest
+	}
+	return http.StatusBadGateway
+}
+
 func (s *Server) Serve(host string, port int) {
-	listenHost := fmt.Sprintf("%s:%d", host, port)
+	if s.opts.ReconcileInterval > 0 {
+		go s.runReconciler(s.opts.ReconcileInterval)
+	} else {
+		go func() {
+			if err := s.discoverAllTargets(); err != nil {
+				s.logger.Sugar().Warnw("Failed to get targets of managed clusters", "err", err)
+			}
+		}()
+	}
+	listenHost := net.JoinHostPort(host, strconv.Itoa(port))
 	s.logger.Sugar().Infow("Starting HTTP server", "host", listenHost)
 	s.logger.Sugar().Fatalw("HTTP server exited", "err", s.echo.Start(listenHost))
 }
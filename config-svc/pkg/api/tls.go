@@ -0,0 +1,112 @@
+// Copyright 2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	v1 "github.com/couchbaselabs/observability/config-svc/pkg/api/v1"
+	"github.com/couchbaselabs/observability/config-svc/pkg/prometheus"
+	"github.com/labstack/echo/v4"
+)
+
+// clusterTLSSettings returns the TLS settings of a cluster given to the API, or nil if it does not use TLS or uses the
+// default settings.
+func clusterTLSSettings(cbConfig v1.CouchbaseConfig) *v1.TLSConfig {
+	if cbConfig.UseTLS == nil || !*cbConfig.UseTLS {
+		return nil
+	}
+	return cbConfig.TlsConfig
+}
+
+// clusterTLSConfig returns the TLS config to discover a cluster with, given its settings from the API.
+func clusterTLSConfig(cbConfig v1.CouchbaseConfig) (*tls.Config, error) {
+	settings := clusterTLSSettings(cbConfig)
+	if settings == nil {
+		return nil, nil
+	}
+	var caPEM, certPEM, keyPEM []byte
+	if settings.CaCert != nil {
+		caPEM = []byte(*settings.CaCert)
+	}
+	if settings.ClientCert != nil {
+		certPEM = []byte(*settings.ClientCert)
+	}
+	if settings.ClientKey != nil {
+		keyPEM = []byte(*settings.ClientKey)
+	}
+	var serverName string
+	if settings.ServerName != nil {
+		serverName = *settings.ServerName
+	}
+	insecureSkipVerify := settings.InsecureSkipVerify != nil && *settings.InsecureSkipVerify
+	tlsConfig, err := prometheus.NewClientTLSConfig(caPEM, certPEM, keyPEM, serverName, insecureSkipVerify)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid TLS config: %v", err))
+	}
+	return tlsConfig, nil
+}
+
+// scrapeTLSConfig returns the tls_config for Prometheus to scrape a cluster with, given its settings from the API, and
+// the certificate files it refers to, to be written along with the config by writeManagedConfig.
+func (s *Server) scrapeTLSConfig(jobName string, cbConfig v1.CouchbaseConfig) (*prometheus.TLSConfig,
+	map[string][]byte, error) {
+	settings := clusterTLSSettings(cbConfig)
+	if settings == nil {
+		return nil, nil, nil
+	}
+	var tlsConfig prometheus.TLSConfig
+	if settings.ServerName != nil {
+		tlsConfig.ServerName = *settings.ServerName
+	}
+	tlsConfig.InsecureSkipVerify = settings.InsecureSkipVerify != nil && *settings.InsecureSkipVerify
+
+	files := make(map[string][]byte)
+	for _, cert := range []struct {
+		pem    *string
+		suffix string
+		path   *string
+	}{
+		{settings.CaCert, caFileSuffix, &tlsConfig.CAFile},
+		{settings.ClientCert, certFileSuffix, &tlsConfig.CertFile},
+		{settings.ClientKey, keyFileSuffix, &tlsConfig.KeyFile},
+	} {
+		if cert.pem == nil || *cert.pem == "" {
+			continue
+		}
+		// The Prometheus versions we support can only read certificates from files
+		if s.opts.SecretsDir == "" {
+			return nil, nil, echo.NewHTTPError(http.StatusBadRequest, "certificates can only be used when the "+
+				"configuration service has a secrets directory to keep them in")
+		}
+		*cert.path = s.secretFile(jobName, cert.suffix)
+		files[*cert.path] = []byte(*cert.pem)
+	}
+
+	if tlsConfig == (prometheus.TLSConfig{}) {
+		return nil, nil, nil
+	}
+	return &tlsConfig, files, nil
+}
+
+// setTLSConfig sets the TLS config of a scrape config and its service discovery. Its exporter job has none, as the
+// exporters serve plain HTTP.
+func setTLSConfig(sc *prometheus.ScrapeConfig, tlsConfig *prometheus.TLSConfig) {
+	for _, client := range httpClientConfigs(sc) {
+		client.TLSConfig = tlsConfig
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/couchbase/tools-common/cbrest"
+	"github.com/couchbase/tools-common/cbvalue"
+	v1 "github.com/couchbaselabs/observability/config-svc/pkg/api/v1"
+	"github.com/couchbaselabs/observability/config-svc/pkg/couchbase"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPostClustersAddTLS(t *testing.T) {
+	promCfgPath, testCluster := setupForTest(t, cbrest.TestClusterOptions{
+		UUID: testClusterUUID,
+		Handlers: map[string]http.HandlerFunc{
+			"GET:/pools/default": func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				_ = json.NewEncoder(w).Encode(&couchbase.PoolsDefault{
+					ClusterName: "Test Cluster",
+					Nodes: []couchbase.Node{
+						{
+							Hostname: "test",
+							Version:  cbvalue.Version7_0_0,
+						},
+					},
+				})
+			},
+		},
+		TLSConfig: &tls.Config{MinVersion: tls.VersionTLS12},
+	})
+	defer testCluster.Close()
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: testCluster.Certificate().Raw})
+	secretsDir := filepath.Join(t.TempDir(), "secrets")
+
+	addCluster := func(tlsConfig string) error {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/clusters/add", strings.NewReader(fmt.Sprintf(`{
+			"hostname": "%s",
+			"couchbaseConfig": {
+				"username": "Administrator",
+				"password": "asdasd",
+				"managementPort": %d,
+				"useTLS": true,
+				"tlsConfig": %s
+			}
+		}`, testCluster.Hostname(), testCluster.Port(), tlsConfig)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		h := &Server{
+			baseLogger: zap.NewNop(),
+			logger:     zap.NewNop(),
+			echo:       e,
+			production: true,
+			opts: Options{
+				SecretsDir: secretsDir,
+			},
+		}
+		return h.PostClustersAdd(e.NewContext(req, rec), v1.PostClustersAddParams{})
+	}
+
+	t.Run("UnknownCA", func(t *testing.T) {
+		require.Error(t, addCluster("{}"))
+
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Equal(t, basePromConfig, string(result))
+	})
+
+	t.Run("InvalidCA", func(t *testing.T) {
+		err := addCluster(`{"caCert": "not a certificate"}`)
+		var httpErr *echo.HTTPError
+		require.ErrorAs(t, err, &httpErr)
+		require.Equal(t, http.StatusBadRequest, httpErr.Code)
+	})
+
+	t.Run("CustomCA", func(t *testing.T) {
+		caCert, err := json.Marshal(string(caPEM))
+		require.NoError(t, err)
+		// The test certificate is only valid for example.com and the loopback addresses
+		require.NoError(t, addCluster(fmt.Sprintf(`{"caCert": %s, "serverName": "example.com"}`, caCert)))
+
+		caFile := filepath.Join(secretsDir, "couchbase-server-managed-6d3e2b8a.ca.pem")
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Equal(t, basePromConfig+fmt.Sprintf(`    # CMOS managed: 6d3e2b8a seed=localhost:%d
+    - job_name: couchbase-server-managed-6d3e2b8a
+      metrics_path: /metrics
+      scheme: https
+      basic_auth:
+        username: Administrator
+        password_file: %s
+      tls_config:
+        ca_file: %s
+        server_name: example.com
+      static_configs:
+        - targets:
+            - test:18091
+          labels:
+            cluster_name: Test Cluster
+            cluster_uuid: 6d3e2b8a
+`, testCluster.Port(), filepath.Join(secretsDir, "couchbase-server-managed-6d3e2b8a.password"), caFile),
+			string(result))
+
+		written, err := os.ReadFile(caFile)
+		require.NoError(t, err)
+		require.Equal(t, caPEM, written)
+	})
+
+	t.Run("UpdateKeepsTLS", func(t *testing.T) {
+		caCert, err := json.Marshal(string(caPEM))
+		require.NoError(t, err)
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/clusters/"+testClusterUUID, strings.NewReader(fmt.Sprintf(`{
+			"couchbaseConfig": {
+				"username": "Administrator",
+				"password": "asdasd",
+				"tlsConfig": {"caCert": %s, "serverName": "example.com"}
+			}
+		}`, caCert)))
+		req.Header.Set("Content-Type", "application/json")
+		h := &Server{
+			baseLogger: zap.NewNop(),
+			logger:     zap.NewNop(),
+			echo:       e,
+			production: true,
+			opts: Options{
+				SecretsDir: secretsDir,
+			},
+		}
+		// Neither the port nor whether to use TLS are given, so both are kept
+		require.NoError(t, h.PutClustersId(e.NewContext(req, httptest.NewRecorder()), testClusterUUID,
+			v1.PutClustersIdParams{}))
+
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Contains(t, string(result), "scheme: https")
+		require.Contains(t, string(result), "- test:18091")
+	})
+}
+
+// generateClientCertificate returns a self-signed client certificate and its key, PEM encoded.
+func generateClientCertificate(t *testing.T) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "cmos"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestPostClustersAddClientCertificate(t *testing.T) {
+	promCfgPath, testCluster := setupForTest(t, cbrest.TestClusterOptions{
+		UUID: testClusterUUID,
+		Handlers: map[string]http.HandlerFunc{
+			"GET:/pools/default": func(w http.ResponseWriter, r *http.Request) {
+				if _, _, ok := r.BasicAuth(); ok {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+				_ = json.NewEncoder(w).Encode(&couchbase.PoolsDefault{
+					ClusterName: "Test Cluster",
+					Nodes: []couchbase.Node{
+						{
+							Hostname: "test",
+							Version:  cbvalue.Version7_0_0,
+						},
+					},
+				})
+			},
+		},
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ClientAuth: tls.RequireAnyClientCert,
+		},
+	})
+	defer testCluster.Close()
+	certPEM, keyPEM := generateClientCertificate(t)
+	secretsDir := filepath.Join(t.TempDir(), "secrets")
+
+	addCluster := func(tlsConfig string) error {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/clusters/add", strings.NewReader(fmt.Sprintf(`{
+			"hostname": "%s",
+			"couchbaseConfig": {
+				"managementPort": %d,
+				"useTLS": true,
+				"tlsConfig": %s
+			}
+		}`, testCluster.Hostname(), testCluster.Port(), tlsConfig)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		h := &Server{
+			baseLogger: zap.NewNop(),
+			logger:     zap.NewNop(),
+			echo:       e,
+			production: true,
+			opts: Options{
+				SecretsDir: secretsDir,
+			},
+		}
+		return h.PostClustersAdd(e.NewContext(req, rec), v1.PostClustersAddParams{})
+	}
+
+	t.Run("NoCredentials", func(t *testing.T) {
+		err := addCluster(`{"insecureSkipVerify": true}`)
+		var httpErr *echo.HTTPError
+		require.ErrorAs(t, err, &httpErr)
+		require.Equal(t, http.StatusBadRequest, httpErr.Code)
+	})
+
+	t.Run("ClientCertificate", func(t *testing.T) {
+		clientCert, err := json.Marshal(string(certPEM))
+		require.NoError(t, err)
+		clientKey, err := json.Marshal(string(keyPEM))
+		require.NoError(t, err)
+		require.NoError(t, addCluster(fmt.Sprintf(`{"clientCert": %s, "clientKey": %s, "insecureSkipVerify": true}`,
+			clientCert, clientKey)))
+
+		certFile := filepath.Join(secretsDir, "couchbase-server-managed-6d3e2b8a.cert.pem")
+		keyFile := filepath.Join(secretsDir, "couchbase-server-managed-6d3e2b8a.key.pem")
+		result, err := os.ReadFile(promCfgPath)
+		require.NoError(t, err)
+		require.Equal(t, basePromConfig+fmt.Sprintf(`    # CMOS managed: 6d3e2b8a seed=localhost:%d
+    - job_name: couchbase-server-managed-6d3e2b8a
+      metrics_path: /metrics
+      scheme: https
+      tls_config:
+        cert_file: %s
+        key_file: %s
+        insecure_skip_verify: true
+      static_configs:
+        - targets:
+            - test:18091
+          labels:
+            cluster_name: Test Cluster
+            cluster_uuid: 6d3e2b8a
+`, testCluster.Port(), certFile, keyFile), string(result))
+
+		for path, contents := range map[string][]byte{certFile: certPEM, keyFile: keyPEM} {
+			written, err := os.ReadFile(path)
+			require.NoError(t, err)
+			require.Equal(t, contents, written)
+		}
+	})
+}
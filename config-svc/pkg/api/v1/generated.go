@@ -7,69 +7,418 @@ import (
 	"bytes"
 	"compress/gzip"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/url"
 	"path"
 	"strings"
+	"time"
 
+	"github.com/deepmap/oapi-codegen/pkg/runtime"
 	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
 )
 
+// Defines values for ClusterValidationErrorKind.
+const (
+	ClusterValidationErrorKindAuth ClusterValidationErrorKind = "auth"
+
+	ClusterValidationErrorKindConnect ClusterValidationErrorKind = "connect"
+
+	ClusterValidationErrorKindDns ClusterValidationErrorKind = "dns"
+
+	ClusterValidationErrorKindHttp ClusterValidationErrorKind = "http"
+
+	ClusterValidationErrorKindTls ClusterValidationErrorKind = "tls"
+)
+
+// Defines values for CouchbaseConfigNetwork.
+const (
+	CouchbaseConfigNetworkAuto CouchbaseConfigNetwork = "auto"
+
+	CouchbaseConfigNetworkDefault CouchbaseConfigNetwork = "default"
+
+	CouchbaseConfigNetworkExternal CouchbaseConfigNetwork = "external"
+)
+
+// Defines values for ErrorResponseOk.
+const (
+	ErrorResponseOkFalse ErrorResponseOk = false
+)
+
+// Defines values for ManagedClusterKind.
+const (
+	ManagedClusterKindCouchbaseServer ManagedClusterKind = "couchbase-server"
+
+	ManagedClusterKindSyncGateway ManagedClusterKind = "sync-gateway"
+)
+
+// Outcome of one check. Checks that were not run because an earlier one failed are not ok.
+type CheckResult struct {
+	Message *string `json:"message,omitempty"`
+	Ok      bool    `json:"ok"`
+}
+
 // Cluster defines model for Cluster.
 type Cluster struct {
-	CouchbaseConfig struct {
-		ManagementPort *float32 `json:"managementPort,omitempty"`
-		Password       string   `json:"password"`
-		UseTLS         *bool    `json:"useTLS,omitempty"`
-		Username       string   `json:"username"`
-	} `json:"couchbaseConfig"`
-	Hostname      string `json:"hostname"`
-	MetricsConfig *struct {
-		MetricsPort *float32 `json:"metricsPort,omitempty"`
-	} `json:"metricsConfig,omitempty"`
-	Name *string `json:"name,omitempty"`
+	// How to connect to a cluster. It is authenticated with the username and password, or if there are none, with the client certificate in tlsConfig.
+	CouchbaseConfig CouchbaseConfig `json:"couchbaseConfig"`
+
+	// Hostname of a node of the cluster, or a connection string listing several to try in turn, such as couchbase://node1,node2. As with the SDKs, the ports of the hosts are those of the data service, so hosts with the default one (11210 or 11207) or without a port are contacted on managementPort instead, and other ports are refused. A single host without a port may be the name of DNS SRV records listing the nodes, which are looked up again whenever the cluster is checked. The couchbases:// scheme turns on useTLS, and the network option is used if network is not given.
+	Hostname      string         `json:"hostname"`
+	MetricsConfig *MetricsConfig `json:"metricsConfig,omitempty"`
+	Name          *string        `json:"name,omitempty"`
+}
+
+// ClusterUpdate defines model for ClusterUpdate.
+type ClusterUpdate struct {
+	// How to connect to a cluster. It is authenticated with the username and password, or if there are none, with the client certificate in tlsConfig.
+	CouchbaseConfig CouchbaseConfig `json:"couchbaseConfig"`
+	MetricsConfig   *MetricsConfig  `json:"metricsConfig,omitempty"`
+}
+
+// ClusterValidation defines model for ClusterValidation.
+type ClusterValidation struct {
+	ClusterName *string `json:"clusterName,omitempty"`
+	ClusterUUID *string `json:"clusterUUID,omitempty"`
+
+	// Why the cluster itself could not be contacted
+	Error *string `json:"error,omitempty"`
+
+	// How contacting the cluster failed: its hostname could not be resolved, it could not be connected to, the TLS connection could not be established, the credentials were rejected, or it returned an error
+	ErrorKind *ClusterValidationErrorKind `json:"errorKind,omitempty"`
+	Nodes     []NodeValidation            `json:"nodes"`
+
+	// Whether the cluster could be contacted and every node passed all checks
+	Ok       bool     `json:"ok"`
+	Warnings []string `json:"warnings"`
+}
+
+// How contacting the cluster failed: its hostname could not be resolved, it could not be connected to, the TLS connection could not be established, the credentials were rejected, or it returned an error
+type ClusterValidationErrorKind string
+
+// Changes between two revisions of the managed config. Passwords are redacted.
+type ConfigDiff struct {
+	// Unified diff of the config file, empty if the revisions are the same
+	Diff string `json:"diff"`
+}
+
+// Changes a dry run would have made. Passwords are redacted.
+type ConfigPreview struct {
+	// Unified diff of the config file, empty if it would not change
+	Diff string `json:"diff"`
+
+	// The generated scrape config, as YAML
+	ScrapeConfig string `json:"scrapeConfig"`
+}
+
+// A version of the managed config written by the service
+type ConfigRevision struct {
+	// Hash of the config, the same as its ETag without the quotes
+	Hash string `json:"hash"`
+	Id   int    `json:"id"`
+
+	// The change that produced this revision
+	Operation string    `json:"operation"`
+	Timestamp time.Time `json:"timestamp"`
+
+	// Who made the change, as far as the service can tell
+	User string `json:"user"`
+}
+
+// How to connect to a cluster. It is authenticated with the username and password, or if there are none, with the client certificate in tlsConfig.
+type CouchbaseConfig struct {
+	// Port of the management service, 8091 by default or 18091 with TLS. Updating a cluster without one keeps its current port.
+	ManagementPort *float32 `json:"managementPort,omitempty"`
+
+	// Which addresses to scrape the nodes on: their own (default), or their external alternate addresses (external). Auto uses the external addresses if the hostname is one of them rather than that of a node, as the SDKs do.
+	Network  *CouchbaseConfigNetwork `json:"network,omitempty"`
+	Password *string                 `json:"password,omitempty"`
+
+	// How to connect to a cluster over TLS, both to discover its nodes and for Prometheus to scrape them. Only used with useTLS. Certificates and keys are PEM encoded.
+	TlsConfig *TLSConfig `json:"tlsConfig,omitempty"`
+	UseTLS    *bool      `json:"useTLS,omitempty"`
+	Username  *string    `json:"username,omitempty"`
+}
+
+// Which addresses to scrape the nodes on: their own (default), or their external alternate addresses (external). Auto uses the external addresses if the hostname is one of them rather than that of a node, as the SDKs do.
+type CouchbaseConfigNetwork string
+
+// ErrorResponse defines model for ErrorResponse.
+type ErrorResponse struct {
+	Error string          `json:"error"`
+	Ok    ErrorResponseOk `json:"ok"`
+}
+
+// ErrorResponseOk defines model for ErrorResponse.Ok.
+type ErrorResponseOk bool
+
+// ManagedCluster defines model for ManagedCluster.
+type ManagedCluster struct {
+	// Where Prometheus discovers the targets from, if they are not static
+	HttpSDURL   *string            `json:"httpSDURL,omitempty"`
+	Id          string             `json:"id"`
+	Kind        ManagedClusterKind `json:"kind"`
+	MetricsPath string             `json:"metricsPath"`
+	Name        *string            `json:"name,omitempty"`
+
+	// Outcome of the background check of a Couchbase cluster's topology
+	Reconcile *ReconcileStatus `json:"reconcile,omitempty"`
+	Targets   []string         `json:"targets"`
+	UseTLS    bool             `json:"useTLS"`
+}
+
+// ManagedClusterKind defines model for ManagedCluster.Kind.
+type ManagedClusterKind string
+
+// MetricsConfig defines model for MetricsConfig.
+type MetricsConfig struct {
+	ExporterPassword *string `json:"exporterPassword,omitempty"`
+
+	// Username of the exporter running alongside Couchbase Server nodes older than 7.0, if it needs one. Those nodes are scraped by a job of their own, as the credentials of the cluster are not those of the exporter.
+	ExporterUsername *string `json:"exporterUsername,omitempty"`
+
+	// Port to scrape Sync Gateway on, or the exporter running alongside Couchbase Server nodes older than 7.0
+	MetricsPort *float32 `json:"metricsPort,omitempty"`
+}
+
+// NodeValidation defines model for NodeValidation.
+type NodeValidation struct {
+	// Outcome of one check. Checks that were not run because an earlier one failed are not ok.
+	Auth CheckResult `json:"auth"`
+
+	// Outcome of one check. Checks that were not run because an earlier one failed are not ok.
+	Dns CheckResult `json:"dns"`
+
+	// The node's hostname as reported by the cluster
+	Hostname string `json:"hostname"`
+
+	// Whether the target is a loopback address, which Prometheus would resolve to itself
+	Loopback bool `json:"loopback"`
+
+	// Outcome of one check. Checks that were not run because an earlier one failed are not ok.
+	Reachable CheckResult `json:"reachable"`
+
+	// The address Prometheus would scrape
+	Target string `json:"target"`
+
+	// Outcome of one check. Checks that were not run because an earlier one failed are not ok.
+	Tls     *CheckResult `json:"tls,omitempty"`
+	Version *string      `json:"version,omitempty"`
+}
+
+// Outcome of the background check of a Couchbase cluster's topology
+type ReconcileStatus struct {
+	LastAttempt time.Time  `json:"lastAttempt"`
+	LastError   *string    `json:"lastError,omitempty"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
 }
 
 // Sgw defines model for Sgw.
 type Sgw struct {
-	Hostname      string `json:"hostname"`
-	MetricsConfig *struct {
-		MetricsPort *float32 `json:"metricsPort,omitempty"`
-	} `json:"metricsConfig,omitempty"`
-	Name      *string `json:"name,omitempty"`
-	SgwConfig struct {
+	Hostname      string         `json:"hostname"`
+	MetricsConfig *MetricsConfig `json:"metricsConfig,omitempty"`
+	Name          *string        `json:"name,omitempty"`
+	SgwConfig     struct {
 		Password string `json:"password"`
 		Username string `json:"username"`
 	} `json:"sgwConfig"`
 }
 
+// How to connect to a cluster over TLS, both to discover its nodes and for Prometheus to scrape them. Only used with useTLS. Certificates and keys are PEM encoded.
+type TLSConfig struct {
+	// CA certificates to verify the cluster's certificates with, instead of the system ones
+	CaCert *string `json:"caCert,omitempty"`
+
+	// Certificate to present to the cluster, if it asks for one
+	ClientCert *string `json:"clientCert,omitempty"`
+
+	// Private key of the client certificate
+	ClientKey *string `json:"clientKey,omitempty"`
+
+	// Do not verify the cluster's certificates at all
+	InsecureSkipVerify *bool `json:"insecureSkipVerify,omitempty"`
+
+	// Name to verify the cluster's certificates against, instead of each node's hostname
+	ServerName *string `json:"serverName,omitempty"`
+}
+
+// A group of targets in the format of Prometheus' HTTP service discovery
+type TargetGroup struct {
+	Labels  *TargetGroup_Labels `json:"labels,omitempty"`
+	Targets []string            `json:"targets"`
+}
+
+// TargetGroup_Labels defines model for TargetGroup.Labels.
+type TargetGroup_Labels struct {
+	AdditionalProperties map[string]string `json:"-"`
+}
+
+// IfMatch defines model for IfMatch.
+type IfMatch string
+
 // PostClustersAddJSONBody defines parameters for PostClustersAdd.
 type PostClustersAddJSONBody = Cluster
 
+// PostClustersAddParams defines parameters for PostClustersAdd.
+type PostClustersAddParams struct {
+	// Return the changes that would be made to the config file instead of making them
+	DryRun *bool `json:"dryRun,omitempty"`
+
+	// Only make the change if the managed clusters are still at this version, as given by the ETag of an earlier response
+	IfMatch *IfMatch `json:"If-Match,omitempty"`
+}
+
+// PostClustersValidateJSONBody defines parameters for PostClustersValidate.
+type PostClustersValidateJSONBody = Cluster
+
+// DeleteClustersIdParams defines parameters for DeleteClustersId.
+type DeleteClustersIdParams struct {
+	// Only make the change if the managed clusters are still at this version, as given by the ETag of an earlier response
+	IfMatch *IfMatch `json:"If-Match,omitempty"`
+}
+
+// PutClustersIdJSONBody defines parameters for PutClustersId.
+type PutClustersIdJSONBody = ClusterUpdate
+
+// PutClustersIdParams defines parameters for PutClustersId.
+type PutClustersIdParams struct {
+	// Only make the change if the managed clusters are still at this version, as given by the ETag of an earlier response
+	IfMatch *IfMatch `json:"If-Match,omitempty"`
+}
+
+// GetHistoryDiffParams defines parameters for GetHistoryDiff.
+type GetHistoryDiffParams struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// PostHistoryRevisionRollbackParams defines parameters for PostHistoryRevisionRollback.
+type PostHistoryRevisionRollbackParams struct {
+	// Only make the change if the managed clusters are still at this version, as given by the ETag of an earlier response
+	IfMatch *IfMatch `json:"If-Match,omitempty"`
+}
+
 // PostSgwAddJSONBody defines parameters for PostSgwAdd.
 type PostSgwAddJSONBody = Sgw
 
+// PostSgwAddParams defines parameters for PostSgwAdd.
+type PostSgwAddParams struct {
+	// Return the changes that would be made to the config file instead of making them
+	DryRun *bool `json:"dryRun,omitempty"`
+
+	// Only make the change if the managed clusters are still at this version, as given by the ETag of an earlier response
+	IfMatch *IfMatch `json:"If-Match,omitempty"`
+}
+
 // PostClustersAddJSONRequestBody defines body for PostClustersAdd for application/json ContentType.
 type PostClustersAddJSONRequestBody = PostClustersAddJSONBody
 
+// PostClustersValidateJSONRequestBody defines body for PostClustersValidate for application/json ContentType.
+type PostClustersValidateJSONRequestBody = PostClustersValidateJSONBody
+
+// PutClustersIdJSONRequestBody defines body for PutClustersId for application/json ContentType.
+type PutClustersIdJSONRequestBody = PutClustersIdJSONBody
+
 // PostSgwAddJSONRequestBody defines body for PostSgwAdd for application/json ContentType.
 type PostSgwAddJSONRequestBody = PostSgwAddJSONBody
 
+// Getter for additional properties for TargetGroup_Labels. Returns the specified
+// element and whether it was found
+func (a TargetGroup_Labels) Get(fieldName string) (value string, found bool) {
+	if a.AdditionalProperties != nil {
+		value, found = a.AdditionalProperties[fieldName]
+	}
+	return
+}
+
+// Setter for additional properties for TargetGroup_Labels
+func (a *TargetGroup_Labels) Set(fieldName string, value string) {
+	if a.AdditionalProperties == nil {
+		a.AdditionalProperties = make(map[string]string)
+	}
+	a.AdditionalProperties[fieldName] = value
+}
+
+// Override default JSON handling for TargetGroup_Labels to handle AdditionalProperties
+func (a *TargetGroup_Labels) UnmarshalJSON(b []byte) error {
+	object := make(map[string]json.RawMessage)
+	err := json.Unmarshal(b, &object)
+	if err != nil {
+		return err
+	}
+
+	if len(object) != 0 {
+		a.AdditionalProperties = make(map[string]string)
+		for fieldName, fieldBuf := range object {
+			var fieldVal string
+			err := json.Unmarshal(fieldBuf, &fieldVal)
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("error unmarshaling field %s", fieldName))
+			}
+			a.AdditionalProperties[fieldName] = fieldVal
+		}
+	}
+	return nil
+}
+
+// Override default JSON handling for TargetGroup_Labels to handle AdditionalProperties
+func (a TargetGroup_Labels) MarshalJSON() ([]byte, error) {
+	var err error
+	object := make(map[string]json.RawMessage)
+
+	for fieldName, field := range a.AdditionalProperties {
+		object[fieldName], err = json.Marshal(field)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("error marshaling '%s'", fieldName))
+		}
+	}
+	return json.Marshal(object)
+}
+
 // ServerInterface represents all server handlers.
 type ServerInterface interface {
+	// List the Couchbase clusters and Sync Gateways managed by CMOS
+	// (GET /clusters)
+	GetClusters(ctx echo.Context) error
 	// Add a new Couchbase cluster to Prometheus
 	// (POST /clusters/add)
-	PostClustersAdd(ctx echo.Context) error
+	PostClustersAdd(ctx echo.Context, params PostClustersAddParams) error
+	// Check that Prometheus will be able to scrape a Couchbase cluster, without adding it
+	// (POST /clusters/validate)
+	PostClustersValidate(ctx echo.Context) error
+	// Stop monitoring a managed Couchbase cluster or Sync Gateway
+	// (DELETE /clusters/{id})
+	DeleteClustersId(ctx echo.Context, id string, params DeleteClustersIdParams) error
+	// Update the credentials and connection settings of a managed Couchbase cluster
+	// (PUT /clusters/{id})
+	PutClustersId(ctx echo.Context, id string, params PutClustersIdParams) error
 	// Collects diagnostic information about CMOS for Support analysis.
 	// (POST /collectInformation)
 	PostCollectInformation(ctx echo.Context) error
+	// List the revisions of the managed config kept by the service, newest first
+	// (GET /history)
+	GetHistory(ctx echo.Context) error
+	// Show the changes between two revisions of the managed config
+	// (GET /history/diff)
+	GetHistoryDiff(ctx echo.Context, params GetHistoryDiffParams) error
+	// Restore the managed config to an earlier revision
+	// (POST /history/{revision}/rollback)
+	PostHistoryRevisionRollback(ctx echo.Context, revision int, params PostHistoryRevisionRollbackParams) error
 	// Outputs the OpenAPI specification for this API.
 	// (GET /openapi.json)
 	GetOpenapiJson(ctx echo.Context) error
+	// Prometheus HTTP service discovery for the managed Couchbase clusters
+	// (GET /sd/couchbase)
+	GetSdCouchbase(ctx echo.Context) error
+	// Prometheus HTTP service discovery for the managed Sync Gateways
+	// (GET /sd/sync-gateway)
+	GetSdSyncGateway(ctx echo.Context) error
 	// Add a new Sync Gateway cluster to Prometheus
 	// (POST /sgw/add)
-	PostSgwAdd(ctx echo.Context) error
+	PostSgwAdd(ctx echo.Context, params PostSgwAddParams) error
 }
 
 // ServerInterfaceWrapper converts echo contexts to parameters.
@@ -77,12 +426,128 @@ type ServerInterfaceWrapper struct {
 	Handler ServerInterface
 }
 
+// GetClusters converts echo context to params.
+func (w *ServerInterfaceWrapper) GetClusters(ctx echo.Context) error {
+	var err error
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.GetClusters(ctx)
+	return err
+}
+
 // PostClustersAdd converts echo context to params.
 func (w *ServerInterfaceWrapper) PostClustersAdd(ctx echo.Context) error {
 	var err error
 
+	// Parameter object where we will unmarshal all parameters from the context
+	var params PostClustersAddParams
+	// ------------- Optional query parameter "dryRun" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "dryRun", ctx.QueryParams(), &params.DryRun)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter dryRun: %s", err))
+	}
+
+	headers := ctx.Request().Header
+	// ------------- Optional header parameter "If-Match" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("If-Match")]; found {
+		var IfMatch IfMatch
+		n := len(valueList)
+		if n != 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Expected one value for If-Match, got %d", n))
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "If-Match", runtime.ParamLocationHeader, valueList[0], &IfMatch)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter If-Match: %s", err))
+		}
+
+		params.IfMatch = &IfMatch
+	}
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.PostClustersAdd(ctx, params)
+	return err
+}
+
+// PostClustersValidate converts echo context to params.
+func (w *ServerInterfaceWrapper) PostClustersValidate(ctx echo.Context) error {
+	var err error
+
 	// Invoke the callback with all the unmarshalled arguments
-	err = w.Handler.PostClustersAdd(ctx)
+	err = w.Handler.PostClustersValidate(ctx)
+	return err
+}
+
+// DeleteClustersId converts echo context to params.
+func (w *ServerInterfaceWrapper) DeleteClustersId(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "id" -------------
+	var id string
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "id", runtime.ParamLocationPath, ctx.Param("id"), &id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter id: %s", err))
+	}
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params DeleteClustersIdParams
+
+	headers := ctx.Request().Header
+	// ------------- Optional header parameter "If-Match" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("If-Match")]; found {
+		var IfMatch IfMatch
+		n := len(valueList)
+		if n != 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Expected one value for If-Match, got %d", n))
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "If-Match", runtime.ParamLocationHeader, valueList[0], &IfMatch)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter If-Match: %s", err))
+		}
+
+		params.IfMatch = &IfMatch
+	}
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.DeleteClustersId(ctx, id, params)
+	return err
+}
+
+// PutClustersId converts echo context to params.
+func (w *ServerInterfaceWrapper) PutClustersId(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "id" -------------
+	var id string
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "id", runtime.ParamLocationPath, ctx.Param("id"), &id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter id: %s", err))
+	}
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params PutClustersIdParams
+
+	headers := ctx.Request().Header
+	// ------------- Optional header parameter "If-Match" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("If-Match")]; found {
+		var IfMatch IfMatch
+		n := len(valueList)
+		if n != 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Expected one value for If-Match, got %d", n))
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "If-Match", runtime.ParamLocationHeader, valueList[0], &IfMatch)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter If-Match: %s", err))
+		}
+
+		params.IfMatch = &IfMatch
+	}
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.PutClustersId(ctx, id, params)
 	return err
 }
 
@@ -95,6 +560,76 @@ func (w *ServerInterfaceWrapper) PostCollectInformation(ctx echo.Context) error
 	return err
 }
 
+// GetHistory converts echo context to params.
+func (w *ServerInterfaceWrapper) GetHistory(ctx echo.Context) error {
+	var err error
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.GetHistory(ctx)
+	return err
+}
+
+// GetHistoryDiff converts echo context to params.
+func (w *ServerInterfaceWrapper) GetHistoryDiff(ctx echo.Context) error {
+	var err error
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params GetHistoryDiffParams
+	// ------------- Required query parameter "from" -------------
+
+	err = runtime.BindQueryParameter("form", true, true, "from", ctx.QueryParams(), &params.From)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter from: %s", err))
+	}
+
+	// ------------- Required query parameter "to" -------------
+
+	err = runtime.BindQueryParameter("form", true, true, "to", ctx.QueryParams(), &params.To)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter to: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.GetHistoryDiff(ctx, params)
+	return err
+}
+
+// PostHistoryRevisionRollback converts echo context to params.
+func (w *ServerInterfaceWrapper) PostHistoryRevisionRollback(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "revision" -------------
+	var revision int
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "revision", runtime.ParamLocationPath, ctx.Param("revision"), &revision)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter revision: %s", err))
+	}
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params PostHistoryRevisionRollbackParams
+
+	headers := ctx.Request().Header
+	// ------------- Optional header parameter "If-Match" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("If-Match")]; found {
+		var IfMatch IfMatch
+		n := len(valueList)
+		if n != 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Expected one value for If-Match, got %d", n))
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "If-Match", runtime.ParamLocationHeader, valueList[0], &IfMatch)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter If-Match: %s", err))
+		}
+
+		params.IfMatch = &IfMatch
+	}
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.PostHistoryRevisionRollback(ctx, revision, params)
+	return err
+}
+
 // GetOpenapiJson converts echo context to params.
 func (w *ServerInterfaceWrapper) GetOpenapiJson(ctx echo.Context) error {
 	var err error
@@ -104,12 +639,56 @@ func (w *ServerInterfaceWrapper) GetOpenapiJson(ctx echo.Context) error {
 	return err
 }
 
+// GetSdCouchbase converts echo context to params.
+func (w *ServerInterfaceWrapper) GetSdCouchbase(ctx echo.Context) error {
+	var err error
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.GetSdCouchbase(ctx)
+	return err
+}
+
+// GetSdSyncGateway converts echo context to params.
+func (w *ServerInterfaceWrapper) GetSdSyncGateway(ctx echo.Context) error {
+	var err error
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.GetSdSyncGateway(ctx)
+	return err
+}
+
 // PostSgwAdd converts echo context to params.
 func (w *ServerInterfaceWrapper) PostSgwAdd(ctx echo.Context) error {
 	var err error
 
+	// Parameter object where we will unmarshal all parameters from the context
+	var params PostSgwAddParams
+	// ------------- Optional query parameter "dryRun" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "dryRun", ctx.QueryParams(), &params.DryRun)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter dryRun: %s", err))
+	}
+
+	headers := ctx.Request().Header
+	// ------------- Optional header parameter "If-Match" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("If-Match")]; found {
+		var IfMatch IfMatch
+		n := len(valueList)
+		if n != 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Expected one value for If-Match, got %d", n))
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "If-Match", runtime.ParamLocationHeader, valueList[0], &IfMatch)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter If-Match: %s", err))
+		}
+
+		params.IfMatch = &IfMatch
+	}
+
 	// Invoke the callback with all the unmarshalled arguments
-	err = w.Handler.PostSgwAdd(ctx)
+	err = w.Handler.PostSgwAdd(ctx, params)
 	return err
 }
 
@@ -141,9 +720,18 @@ func RegisterHandlersWithBaseURL(router EchoRouter, si ServerInterface, baseURL
 		Handler: si,
 	}
 
+	router.GET(baseURL+"/clusters", wrapper.GetClusters)
 	router.POST(baseURL+"/clusters/add", wrapper.PostClustersAdd)
+	router.POST(baseURL+"/clusters/validate", wrapper.PostClustersValidate)
+	router.DELETE(baseURL+"/clusters/:id", wrapper.DeleteClustersId)
+	router.PUT(baseURL+"/clusters/:id", wrapper.PutClustersId)
 	router.POST(baseURL+"/collectInformation", wrapper.PostCollectInformation)
+	router.GET(baseURL+"/history", wrapper.GetHistory)
+	router.GET(baseURL+"/history/diff", wrapper.GetHistoryDiff)
+	router.POST(baseURL+"/history/:revision/rollback", wrapper.PostHistoryRevisionRollback)
 	router.GET(baseURL+"/openapi.json", wrapper.GetOpenapiJson)
+	router.GET(baseURL+"/sd/couchbase", wrapper.GetSdCouchbase)
+	router.GET(baseURL+"/sd/sync-gateway", wrapper.GetSdSyncGateway)
 	router.POST(baseURL+"/sgw/add", wrapper.PostSgwAdd)
 
 }
@@ -151,21 +739,74 @@ func RegisterHandlersWithBaseURL(router EchoRouter, si ServerInterface, baseURL
 // Base64 encoded, gzipped, json marshaled Swagger object
 var swaggerSpec = []string{
 
-	"H4sIAAAAAAAC/9RWTW/jNhP+KwTf9+hISlK0WZ2apsEiRdIE1d7SPdDUSOKGIlnO0KoR+L8XpOX4I3a8",
-	"WbRAexM4nI/nmWeGeubS9s4aMIS8fOYoO+hF+rzSAQl8/BR1rUhZI/SDtw48KUBeNkIjTLjbOIrhguym",
-	"AuHKmka17/TuhREt9GDowXqKJzU0Imji5UXx4XTCae6Al9yEfgqeLybcCcTB+jreHY1IXpk2GgPCp9tq",
-	"wzS1VoMwo80b0cMex8WEe/gjKA81Lx/XNzeyfX4pxU6/gKQYsbNIByJOeA/klcRvY2Xpu6Jkh4PFnlK+",
-	"DthurzYg7MNXtUOMuV3bvx/0hGM7fFMJx7T1t+pnx2ld85tdiW7KNHY5eoaETGxBL5TmZTL9+NLmTNqe",
-	"r3jiV6vjCbsxMuMTHnz06Ygclnm+7baY8BpQeuUiebzkv11Xn9jlww2zDaMO2LLa4EW0swr8TMkIWSsJ",
-	"BhNNY+JLJ2QH7CwrXuUchiETyZxZ3+ajL+a3N1fXv1bXJ9Entl6R3oLA7qxRZCP97PdQFGffs/spgp+J",
-	"qdKK5qwiIZ/YycEqZ+BxiWt2GjNYB0Y4xUt+nhXZeWoddUkVuVwuRsxFnaThLCbSo3RS3Jual/zBIo0r",
-	"FC/rmi/bC0g/2Xq+aheY5Cmc00om3/wLxjJWmzh+/d9Dw0v+v3y9qvNxT+erJb3Y1g/5AOkAnY0ExjBn",
-	"RfGutO8YFPuURGdCz8vHmHst1JeNu6Nv+3RAzdsqG/ExUddQMwxSAmITtJ6niBj6Xvh5FFVdM8EMDGwt",
-	"irFRjCx78LYH6iBg8sul1Rok3ZjG+l4sk73Zydf3j9JL8CflTgtleGmC1q/AVeRB9HGAtG3bqF0byAVi",
-	"jbc9G0s8UeucGXY7sMe6kNVKtMYiKck2HJiY2kDs6u6+Yo31rArOWU9MGKHnqDBbsjGqPVuJoIU9NHwE",
-	"ul/e+wW/Bv7b6jra+ZgrrpfzrGDoQKpmDJaAUKcwbp8dOu4Tf5gW0irAYecRPbbD8VGu2mE1xQ7/2zNV",
-	"zY1kHwXBIOaHBuuf2FXx/+HonoqDsn+ut8o+NNpRDeDjNufl4/MO8FsrhWZ3SnqrFXVbr0+Z5zqa41tb",
-	"XhQXRS7TU5ELp/L0JuyP9jPMQFsXf1kPx/vh9MN3L4E+L/4KAAD//6e0iIJ1CwAA",
+	"H4sIAAAAAAAC/+xcfW8bN5P/KsTeAW2BjWSnvWvrv87nBK2veTEsJ4fDc0FBLUdaxrvkluRaFQJ/98MM",
+	"yX2XJSWtr8/z+J9G3uXLcF5/M5ztpyTTZaUVKGeTs09JDlyAoZ8vb/ga/xVgMyMrJ7VKzpL3YKzUiukV",
+	"czmwkiu+BsGyorYOjE3ZShtWW2Ab6XJ2uXr2mrssT9LEZjmUHBd02wqSs8Q6I9U6ub+/T5OKG16CCztf",
+	"rvyk0eZvVbFlJb8F2jvLuVoDk9OUMG6AWSeLgnHHXC4tu/O0p4xbtpZ3oNhyS3PxqHgirhhwU0gwzICt",
+	"tLKQpInErT1jkjRRvETqDz2Zf0nHusghu70GWxcO/+RCSDwXL66MrsA4CTY5W/HCQjo8d+0yXQKSqBUe",
+	"HLLbGaP1LHM5d2wDBpjSjplasSVkHEXQOQ5OW3FZgGA8jNS3syRNqs7en5ISrOVrmDhLmujbzuOl1gVw",
+	"leARDfxWSwMiOfsbDvqQxkF6+REyh3MvvFD2HrtPTabrLF9yCxdarSQp478aWCVnyb/MW7WdBxbPLwbD",
+	"79Mk19Z5gQ1V6efwhsTOlBYQVTooUMq0YZxlWinIcBLzrGCFtA7/tXAHhhfMaebMlknFXG1Uymyd5ahh",
+	"DfVn8zmuf5rif5/P2Ln1xoGbLV78YlP6VWnjbKQB6fYa7HJtG9IEd5xZMHcyg5RZHcY1qwlY8bpwJO2v",
+	"T0+fn57gIU5Pn598/w3+woG6dozTbrR+ppXjmQPBtAo2VIJyV/heKuuAi5RxJZh2OZhAJU40sKotiBk7",
+	"Z1aqdeGJHm5R8i1benON3H7xZsEW1++ZgUwbYRt+0hgtwKZsk0vkoQFWaH0LgtUV42suFdvkoJDvXUkx",
+	"ab1JIDU3ObSct2fzOSP1ABKOxUPWFm5eLfyhaE9wG21umSbVwMXwXOhW4htpyWDIZcySRr1b0yjBGZnZ",
+	"w9T0dW/wffQoY+/Rt6yhMXSU+wGLe1cJ7uDR7e5LGLLn3A8c9j0vpODewI87sJ//ZloQaXz/7t3li8n3",
+	"YIw2Yx/z3/m2r6fOQrFC9SwEadSyY39JumPdX6QSU/5rE+dG44nbeD9/htuxqCP9TQ1YXdyBSJl0I3LQ",
+	"3YFgTnu/dPNq0XWCvdFgHV8W0uYg/ODMgADlJC+sD0kGPtJy5E2lYwbQDjEKKea5liag6hIlLZRN0iTs",
+	"hewo8G9eOwyyuXNV8mGCR+QykD/SQWn36dobLaCjJvfNgtwYvm3D3FCQQN6vy2XPiK4EyaOgc9r6eFJx",
+	"i46EF4V3TzZJR+EzTTbcKKnW/SOMTtmnchx0Ix86600aCtnQC7laHYlALghrWbYEtwFQzG00M3AnrdTK",
+	"jsAg7TJjV9zaDfl4HzAEsWmMOkSgp7/lOyVXEgTDt01sppXZShaQMigrt43wryXGR01gFq053eNVaevd",
+	"nLrCZWHzmcziTJgtIbINKUvO75BJAh6PNdKxTWOxHjBPORqbGV513H1/O4ypa1BgOGq5Hxv2Iyz9P+ev",
+	"X+3ldG+LdB/jr4M4j+T8ecT40yrJNkY61+L+AKVGXM+5ncg+fuY273M7bRQN2YD+ljKJiIHw5W+1dmCn",
+	"eC5Fx9KlcrAGg8+RkCaIjQURsh5C/ZXRos5A+PQmmsDUZk6W6KzLChddaVNyl5wliA2e4aupKbWFyZim",
+	"SYU7GRjpwIob/KfDVZZxxRwUxV7VkCLpUthlQSAj9SKZ1pcRWDlCYTCMOh0DHP7k0cPP2KVj0jIMQBjT",
+	"MtL+BmsjXRRZ0etXwZx9mCMVMRAyLQVpOysrJCjHMiRqRUtS4lAE9DORkfUg+Vge+LSv7Di2TRJ+OPnx",
+	"FNW9SQ0MO6VnRNLNq8WMEUZEENEcvdFgrYDdAlReubPaGFy80sZ1cLCqy6VX3QCYPZW0X3KGAVwn6UiN",
+	"COELYcBasMj44FeaNIBpdYZ/ScP0RrGvw4rfEI/9c/jdoRAKxgv64aCz5Nfx7Tczdl47jRLzCtpOawbL",
+	"Nu8ioUpLZ/eMLZnhIf5z5S2vyRrTqPWYyTGhZx1A05zcsyJN4saTQCbq0HT8Lw4E0zevFi0M96nOVNqe",
+	"JlF/pzOPkZm9NEab61gUOQ5eN/B4R00h8IvmfkgPKDFExD3lD157j/95FQdEmYsX765fTWJAA+zK6BJc",
+	"DrVlQtpMY7gh8Ttu1uAsWxldpkGdtk2txTruZLY3CrSPbwPoj6rUZEHP0LLJI9qtyp6tuYMN304qVEjC",
+	"rrjLJ/dQu9IdA5lWmSxgn7Jdx4ELx11tcW7gwzFo9iE9nYoUxJt2p/5Bm8UmdWOYlh6jxL+j2wNz9ZCZ",
+	"xkHvOsY1gG7hTfTZcQbCREVOuNBqbaUA1kQ2tiCZR7dYiOiKvp+dpAHlKQBBPgtLINpGH0pFUHKsAqMA",
+	"Zx/1Mmzt/Wrjv7qpW78S1mhxrxYVCX+oIPJAyGod/mKrMvaT12OmVXTvX8yZcYCa8muDhPA4naDcdF91",
+	"pFP1vU8pyT1uxu4a5k0IlV918nxumQFinIgoN4hxSk6F1tWSZ3tSXm9phIVYnBFjZyzWdRyjTzhCjQEF",
+	"7esek9mvAZ7lfFnAkUzxJE2zJFA2Jskr3CQ8Lo6VSsgz9tftGvE1RHfYnoaiR8uGUPCY8l5Db/vZdwgo",
+	"U9x+bXSthC9OeETTGlZQmq8sc7rShV5vR9C04NadO4e55uFZBU56uRMP4NtFnWVg7aFLDtjdJWqKiYs1",
+	"ZfODsN8xsccp7qaJXW8+Kw49CBMfBnVdPjUjO8BzzK/BpJbmPdXnFoX+YekYQ6zFqHK/1C7HdxGBUXIS",
+	"4p0SdAPZsf1ealHOGF0iUomfUiAPF2bsok3J/DK3sPXx8+rlawYq02KqQJNxnDh2RBfn3SSPqLgDI1c9",
+	"l/yV7Q9CgtJ47RIt1W6tg5JpNV1G8BnlDiLaxZGAyoAFRWztXXR5BMHtrSXeaQW7N/oFthMR3cg73OMW",
+	"ti12GCa6U2tKZSGrDSxuZfWe2DNe/IUm8LGfe9xhrXUyzHjQ/GYyiuLTw8RDV1DW9SSEjnsYhCed1NhG",
+	"KBr8ZHRdHV3lQs9dEatD2iEVEe4dJr5oDeAr9vPNzVVTlYlGM+XPl1A8EFZ2A/n2UJ8B/wc+Jq4w4YxI",
+	"YVbaX1FR0R1/QsllQSW0lf6PJk+aZbpsb+ubuJayS5WhFdcG52C+h5eE/Wn3Q4Zfv1zcsPOry6jc3rfV",
+	"vkbFFk0VsZAZhPQ4bHxe8SwH9nx2Mtpzs9nMOL2eabOeh7l2/ury4uWbxctnOIcqd67oHYG91ko6jcxk",
+	"/1ufnDz/d/Z2idLlS1lIt2ULh/js2U4qG+iS3J2GgqPilUzOkm9nJ7NvKSK4nEQ3D5ZAfwTA1RTnLkVy",
+	"lvwE7iKOQUH6AgGNf35yEkUFiqbyqirQlqRW84/Wo6e2e+L4C7vD730GVYF9StisP62Ffe14Peg8SdLQ",
+	"LdJro5miLgyb05h7WtrWZcnNNjlLXknrgrYNQJmPT928yTaF7uWWXbx+u6ATNdKbc0F4odJ2Ikq8pCur",
+	"FuSTHyhifAxr/NrUOuODupZixqLw/eCJPOy0l4NieQSzCDxbk4Y2t/7atoUUnMULqwfkxPIgbZ8y7apf",
+	"fRnOu/hfyTOmDZElYOpnc1kR7cEJNhkuTu2cgUp7uDEoJw0UW6JfG1ZwSkQpserUJCOtLje6XuejvPQr",
+	"O3a7MWESGnxbgRfGEroNEFa3Nwv4yDMhMEUIup4NdEQaltgZ5bI83gbHLKgbo/wtBdtwS40UvSQfH/ql",
+	"6bzWsaYMRLCnb/ZX2jZ2fy5E0m/h+ttQxa7p2rdzZxDbluIlqr9S0MObrG6kLfltOFsZm7J+q30cC85W",
+	"mO11raY6sjr1pGlLbKmfx+6z+w/eJ4B1/6nF9ihX9mAiGX1Q3+k4U8P943nQXuUV9/4whZuq9v7zwSP1",
+	"LksP6gkbu9GLWG0iJbQ+C1zVRbH9fIeaJt+dPv/DJNevgU8c4WaqD5Fufb3WC2alyrylx4tK34goVdsr",
+	"iVQ/f2SqO/lSvCrltnNpLKhOOFHk+Uh5GrVRbShzuE+Tfzt5ZOr7PRnDzhqqKHZID32QTrNCc9EQH84d",
+	"28JI9XXt74E2VFazTqNK98P0uRCM0wKjOI1btPsOQvKdLzpCNy7v9rHv4+i/O590wLbdhpyxgH3NrS1K",
+	"UyvNDBm7LKC03bvVtlzd1EDp1qZ7fcdt03vUNPnOBjKlWp+PUF2Vx17iJTCs03WqChNVs7RtgxQCg5Z0",
+	"A+l/kuLeI7ECHIxl/4KeR+lfTgRYCoCVv/II8Y9uR/rSe6g7+dhQ+NcJTF8WYAyU+u6PDDEn3z2eu3uj",
+	"hxGmNQAfSi5fJE9x7x8/7h0a0Jpk4tCAtnC6YmVbY+CNaMcBTpteGkqQsZ4KZbX7a/myPy2Ehobrvz64",
+	"/zIfWtMxn3zokw99yh0+P3fwzmLUfoHLdL87AuekWlt/RbvTGQeEqYsCMnep/FVAuKJ+IMEYj9/rpxz8",
+	"7uZVwdFtq7ooRnxdOAO8RHoLvV5jENG1q2rny3+BxGey3XNm8yEE94MsE5KvlbZOZqwzgfElwmssddKd",
+	"1aKu/CdNihdbK61H9PNcIt+3D1Wvfw5DHs87N63qB1evBz3R+6rX7QaHuPTrZvSO+vOePn92C5UbdFSn",
+	"aBVgHVtJY11PFvPY0r5HIPSRwjRSGJT9UKkOAQtNj/V9Or2Q08ct8+HPTI3bLzWmwvDwc4yumJLHjqdv",
+	"m07Zjq6Ej+bCxWQ0xAHUzPXGe7/jPy/pK9WnOPp+bnRRxJ6m6QuPa6gKnoVm4E2ui5FKU+25wQT08Sx6",
+	"ru4J8RY4K2pK77naNkeIlWxJNpFzFQK3/zQxEsekDR9AgqCSBIWRdm1LC2RcxY/GlsBqJbC/b7IWH4wm",
+	"2vJ15MEhULvz3cBxNvTPVT3wmhEj+d8n8r3pKPAuC0WSfvx/Iqlp0R90o7ZdQFSUy7kNaHgJoGJB5wmy",
+	"P5U9Pr/sce0fTwEcp/v/Z4gIwzAAheaJWTzmLlTz1o/7L3sIwH6Yf3tdFe6F3Srfzk6YrSDzDUyofSvq",
+	"rZYWm1kG539LCN1bX1xg9+SAr61oW2c6p5+6/O1/JqFX4UvZ3bWl5oY6XqGbWlGjU+5c9cwKVvrPb5Rg",
+	"SrcLuxzMRtqJOPkTuIVo9vlSKRwE3Ls9XmPUPjZNGu67u4ZwvKP40/1cQT6wm6W2EVrv25Evkluve//P",
+	"ERluEXb4xxdal59RXuvNsIFnDAAX681TH8ZRoQY7wZ96MAaFm641PzViPCGzvwIyazsseuq5q8miafee",
+	"coCvdMYL9lpmRhfS5b2m3LP5vMDXubbu7IeTH07mnuA5r+ScWmWnV3sBd1DoqkQW71zv+9Mfv2sW+nD/",
+	"fwMAgbpyXHhNAAA=",
 }
 
 // GetSwagger returns the content of the embedded swagger specification file
@@ -0,0 +1,207 @@
+// Copyright 2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/tools-common/cbvalue"
+	v1 "github.com/couchbaselabs/observability/config-svc/pkg/api/v1"
+	"github.com/couchbaselabs/observability/config-svc/pkg/couchbase"
+	"github.com/labstack/echo/v4"
+)
+
+// nodeProbeTimeout bounds each node's checks, so that an unreachable node does not hold up the response for long.
+const nodeProbeTimeout = 5 * time.Second
+
+// PostClustersValidate checks everything Prometheus will need to scrape a cluster, from each node's point of view,
+// without adding it. Problems are reported in the response rather than as errors, so that they can all be shown at
+// once.
+func (s *Server) PostClustersValidate(ctx echo.Context) error {
+	var data v1.PostClustersValidateJSONRequestBody
+	if err := ctx.Bind(&data); err != nil {
+		return err
+	}
+	seeds, err := clusterSeeds(ctx.Request().Context(), data.Hostname, &data.CouchbaseConfig)
+	if err != nil {
+		return err
+	}
+
+	result := v1.ClusterValidation{
+		Nodes:    make([]v1.NodeValidation, 0),
+		Warnings: make([]string, 0),
+	}
+	for _, seed := range seeds {
+		if host, _, err := net.SplitHostPort(seed); err == nil && isLoopbackHost(host, nil) {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s is a loopback address, which refers to CMOS "+
+				"itself rather than the cluster", host))
+		}
+	}
+
+	scheme, useTLS, _ := couchbaseConnectionSettings(data.CouchbaseConfig)
+	tlsConfig, err := clusterTLSConfig(data.CouchbaseConfig)
+	if err != nil {
+		return err
+	}
+	network, err := clusterNetwork(data.CouchbaseConfig)
+	if err != nil {
+		return err
+	}
+	username, password, err := couchbaseCredentials(data.CouchbaseConfig)
+	if err != nil {
+		return err
+	}
+	client := s.couchbaseClient(tlsConfig)
+	cluster, seed, err := fetchClusterFromAny(ctx.Request().Context(), client, scheme, seeds, username, password)
+	if err != nil {
+		msg := err.Error()
+		if httpErr, ok := err.(*echo.HTTPError); ok {
+			msg = fmt.Sprintf("%v", httpErr.Message)
+		}
+		result.Error = &msg
+		var cbErr *couchbase.Error
+		if errors.As(err, &cbErr) {
+			kind := v1.ClusterValidationErrorKind(cbErr.Kind)
+			result.ErrorKind = &kind
+		}
+		return ctx.JSON(http.StatusOK, result)
+	}
+	result.ClusterName = &cluster.ClusterName
+	result.ClusterUUID = &cluster.UUID
+	network = cluster.ResolveNetwork(network, seed)
+
+	result.Nodes = make([]v1.NodeValidation, len(cluster.Nodes))
+	var wg sync.WaitGroup
+	for i, node := range cluster.Nodes {
+		wg.Add(1)
+		go func(i int, node couchbase.Node) {
+			defer wg.Done()
+			probeCtx, cancel := context.WithTimeout(ctx.Request().Context(), nodeProbeTimeout)
+			defer cancel()
+			result.Nodes[i] = validateNode(probeCtx, client.HTTPClient(), node, network, scheme, useTLS, username,
+				password, data.MetricsConfig)
+		}(i, node)
+	}
+	wg.Wait()
+
+	result.Ok = true
+	for _, node := range result.Nodes {
+		if node.Loopback {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("node %s will be scraped on the loopback address %s, "+
+				"which refers to Prometheus itself rather than the node", node.Hostname, node.Target))
+		}
+		if node.Loopback || !node.Dns.Ok || !node.Reachable.Ok || (node.Tls != nil && !node.Tls.Ok) || !node.Auth.Ok {
+			result.Ok = false
+		}
+	}
+	return ctx.JSON(http.StatusOK, result)
+}
+
+// validateNode resolves the address a node would be scraped on, and fetches its metrics the way Prometheus would.
+func validateNode(ctx context.Context, client *http.Client, node couchbase.Node, network couchbase.Network,
+	scheme string, useTLS bool, username, password string, metricsConfig *v1.MetricsConfig) v1.NodeValidation {
+	result := v1.NodeValidation{Hostname: node.Hostname}
+	if node.Version != "" {
+		version := string(node.Version)
+		result.Version = &version
+	}
+	// Older nodes are scraped through their exporter, which serves plain HTTP and has credentials of its own
+	if !node.Version.AtLeast(cbvalue.Version7_0_0) {
+		scheme, useTLS = "http", false
+		username, password = exporterCredentials(metricsConfig)
+	}
+	if useTLS {
+		result.Tls = &v1.CheckResult{}
+	}
+
+	target, err := nodeMetricsTarget(node, network, useTLS, metricsConfig)
+	if err != nil {
+		result.Dns = failedCheck(err)
+		return result
+	}
+	result.Target = target
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		result.Dns = failedCheck(err)
+		return result
+	}
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		result.Dns = failedCheck(err)
+		return result
+	}
+	resolved := strings.Join(addrs, ", ")
+	result.Dns = v1.CheckResult{Ok: true, Message: &resolved}
+	result.Loopback = isLoopbackHost(host, addrs)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s://%s/metrics", scheme, target), nil)
+	if err != nil {
+		result.Reachable = failedCheck(err)
+		return result
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		if useTLS && couchbase.IsTLSError(err) {
+			result.Reachable = v1.CheckResult{Ok: true}
+			*result.Tls = failedCheck(err)
+			return result
+		}
+		result.Reachable = failedCheck(err)
+		return result
+	}
+	res.Body.Close()
+
+	result.Reachable = v1.CheckResult{Ok: true}
+	if useTLS {
+		*result.Tls = v1.CheckResult{Ok: true}
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+		result.Auth = v1.CheckResult{Ok: true}
+	case http.StatusUnauthorized, http.StatusForbidden:
+		result.Auth = failedCheck(fmt.Errorf("credentials were rejected: %s", res.Status))
+	default:
+		result.Auth = failedCheck(fmt.Errorf("metrics endpoint returned %s", res.Status))
+	}
+	return result
+}
+
+func failedCheck(err error) v1.CheckResult {
+	msg := err.Error()
+	return v1.CheckResult{Ok: false, Message: &msg}
+}
+
+// isLoopbackHost reports whether a hostname, or any of the addresses it resolves to, refers to the local machine.
+func isLoopbackHost(host string, addrs []string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	for _, addr := range append([]string{host}, addrs...) {
+		if ip := net.ParseIP(addr); ip != nil && ip.IsLoopback() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,210 @@
+// Copyright 2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/couchbase/tools-common/cbrest"
+	"github.com/couchbase/tools-common/cbvalue"
+	v1 "github.com/couchbaselabs/observability/config-svc/pkg/api/v1"
+	"github.com/couchbaselabs/observability/config-svc/pkg/couchbase"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPostClustersValidate(t *testing.T) {
+	var testCluster *cbrest.TestCluster
+	testCluster = cbrest.NewTestCluster(t, cbrest.TestClusterOptions{
+		UUID: testClusterUUID,
+		Handlers: map[string]http.HandlerFunc{
+			"GET:/pools/default": func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				_ = json.NewEncoder(w).Encode(&couchbase.PoolsDefault{
+					ClusterName: "Test Cluster",
+					Nodes: []couchbase.Node{
+						{
+							Hostname: fmt.Sprintf("127.0.0.1:%d", testCluster.Port()),
+							Version:  cbvalue.Version7_0_0,
+						},
+						{
+							Hostname: "nonexistent.invalid:8091",
+							Version:  cbvalue.Version7_0_0,
+						},
+					},
+				})
+			},
+			"GET:/metrics": func(w http.ResponseWriter, r *http.Request) {
+				if username, _, _ := r.BasicAuth(); username != "Administrator" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			},
+		},
+	})
+	defer testCluster.Close()
+
+	validate := func(t *testing.T, hostname, username string, port int) v1.ClusterValidation {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/clusters/validate", bytes.NewReader([]byte(fmt.Sprintf(`{
+			"hostname": %q,
+			"couchbaseConfig": {
+				"username": %q,
+				"password": "asdasd",
+				"managementPort": %d
+			}
+		}`, hostname, username, port))))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		h := &Server{
+			baseLogger: zap.NewNop(),
+			logger:     zap.NewNop(),
+			echo:       e,
+			production: true,
+		}
+
+		require.NoError(t, h.PostClustersValidate(e.NewContext(req, rec)))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var result v1.ClusterValidation
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+		return result
+	}
+
+	t.Run("PerNodeResults", func(t *testing.T) {
+		result := validate(t, "localhost", "Administrator", int(testCluster.Port()))
+		require.False(t, result.Ok)
+		require.Nil(t, result.Error)
+		require.Equal(t, "Test Cluster", *result.ClusterName)
+		require.Equal(t, testClusterUUID, *result.ClusterUUID)
+		require.Len(t, result.Warnings, 2)
+		require.Contains(t, result.Warnings[0], "localhost is a loopback address")
+		require.Contains(t, result.Warnings[1], "loopback address 127.0.0.1")
+
+		require.Len(t, result.Nodes, 2)
+		reachable := result.Nodes[0]
+		require.Equal(t, fmt.Sprintf("127.0.0.1:%d", testCluster.Port()), reachable.Target)
+		require.True(t, reachable.Loopback)
+		require.True(t, reachable.Dns.Ok)
+		require.True(t, reachable.Reachable.Ok)
+		require.True(t, reachable.Auth.Ok)
+		require.Nil(t, reachable.Tls)
+
+		unresolvable := result.Nodes[1]
+		require.Equal(t, "nonexistent.invalid:8091", unresolvable.Target)
+		require.False(t, unresolvable.Dns.Ok)
+		require.NotNil(t, unresolvable.Dns.Message)
+		require.False(t, unresolvable.Reachable.Ok)
+		require.False(t, unresolvable.Auth.Ok)
+	})
+
+	t.Run("InvalidCredentials", func(t *testing.T) {
+		result := validate(t, "127.0.0.1", "someone", int(testCluster.Port()))
+		require.False(t, result.Ok)
+		require.True(t, result.Nodes[0].Reachable.Ok)
+		require.False(t, result.Nodes[0].Auth.Ok)
+		require.Contains(t, *result.Nodes[0].Auth.Message, "401")
+	})
+
+	t.Run("ClusterUnreachable", func(t *testing.T) {
+		// Nothing listens on the port of a closed server
+		closed := httptest.NewServer(http.NotFoundHandler())
+		closed.Close()
+		closedURL, err := url.Parse(closed.URL)
+		require.NoError(t, err)
+		closedPort, err := strconv.Atoi(closedURL.Port())
+		require.NoError(t, err)
+
+		result := validate(t, "127.0.0.1", "Administrator", closedPort)
+		require.False(t, result.Ok)
+		require.NotNil(t, result.Error)
+		require.Equal(t, v1.ClusterValidationErrorKindConnect, *result.ErrorKind)
+		require.Empty(t, result.Nodes)
+	})
+}
+
+func TestPostClustersValidateExporter(t *testing.T) {
+	exporter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, _, _ := r.BasicAuth(); username != "exporter" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer exporter.Close()
+	exporterURL, err := url.Parse(exporter.URL)
+	require.NoError(t, err)
+
+	testCluster := cbrest.NewTestCluster(t, cbrest.TestClusterOptions{
+		UUID: testClusterUUID,
+		Handlers: map[string]http.HandlerFunc{
+			"GET:/pools/default": func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				_ = json.NewEncoder(w).Encode(&couchbase.PoolsDefault{
+					ClusterName: "Test Cluster",
+					Nodes: []couchbase.Node{
+						{
+							Hostname: "127.0.0.1:8091",
+							Version:  cbvalue.Version6_6_0,
+						},
+					},
+				})
+			},
+		},
+	})
+	defer testCluster.Close()
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/clusters/validate", bytes.NewReader([]byte(fmt.Sprintf(`{
+		"hostname": "localhost",
+		"couchbaseConfig": {
+			"username": "Administrator",
+			"password": "asdasd",
+			"managementPort": %d
+		},
+		"metricsConfig": {
+			"metricsPort": %s,
+			"exporterUsername": "exporter",
+			"exporterPassword": "exporter-password"
+		}
+	}`, testCluster.Port(), exporterURL.Port()))))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	h := &Server{
+		baseLogger: zap.NewNop(),
+		logger:     zap.NewNop(),
+		echo:       e,
+		production: true,
+	}
+
+	require.NoError(t, h.PostClustersValidate(e.NewContext(req, rec)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var result v1.ClusterValidation
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+
+	// The exporter is checked with its own credentials rather than the cluster's
+	require.Len(t, result.Nodes, 1)
+	require.Equal(t, exporterURL.Host, result.Nodes[0].Target)
+	require.True(t, result.Nodes[0].Reachable.Ok)
+	require.True(t, result.Nodes[0].Auth.Ok)
+}
@@ -0,0 +1,245 @@
+// Copyright 2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package couchbase
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Defaults for the zero values of ClientOptions.
+const (
+	DefaultConnectTimeout = 5 * time.Second
+	DefaultRequestTimeout = 15 * time.Second
+	DefaultMaxAttempts    = 3
+	DefaultRetryBackoff   = 500 * time.Millisecond
+)
+
+// ClientOptions configures how long a Client waits for Couchbase Server, and how it retries.
+type ClientOptions struct {
+	// ConnectTimeout bounds resolving and connecting to a node, and the TLS handshake. Zero means 5 seconds.
+	ConnectTimeout time.Duration
+	// RequestTimeout bounds each attempt at a request, including reading the response. Zero means 15 seconds.
+	RequestTimeout time.Duration
+	// MaxAttempts is how many times a request that may succeed if retried is attempted. Zero means 3, one disables
+	// retries.
+	MaxAttempts int
+	// RetryBackoff is how long to wait before the first retry, doubled before each one after it. Zero means 500ms.
+	RetryBackoff time.Duration
+}
+
+// ErrorKind is how contacting Couchbase Server failed.
+type ErrorKind string
+
+const (
+	// ErrorKindDNS means the hostname could not be resolved.
+	ErrorKindDNS ErrorKind = "dns"
+	// ErrorKindConnect means no connection could be made, or it was lost or timed out before there was a response.
+	ErrorKindConnect ErrorKind = "connect"
+	// ErrorKindTLS means the TLS connection could not be established or verified.
+	ErrorKindTLS ErrorKind = "tls"
+	// ErrorKindAuth means Couchbase Server rejected the credentials.
+	ErrorKindAuth ErrorKind = "auth"
+	// ErrorKindHTTP means Couchbase Server responded with any other unsuccessful status, or an invalid body.
+	ErrorKindHTTP ErrorKind = "http"
+)
+
+// Error is returned when a request to Couchbase Server fails.
+type Error struct {
+	Kind ErrorKind
+	// StatusCode is the status Couchbase Server responded with, if it did.
+	StatusCode int
+	Err        error
+}
+
+func (e *Error) Error() string {
+	switch e.Kind {
+	case ErrorKindDNS:
+		return fmt.Sprintf("failed to resolve Couchbase Server hostname: %v", e.Err)
+	case ErrorKindConnect:
+		return fmt.Sprintf("failed to contact Couchbase Server: %v", e.Err)
+	case ErrorKindTLS:
+		return fmt.Sprintf("failed to establish a TLS connection to Couchbase Server: %v", e.Err)
+	case ErrorKindAuth:
+		return fmt.Sprintf("Couchbase Server rejected the credentials with code %d: %v", e.StatusCode, e.Err)
+	}
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("Couchbase Server returned non-OK code %d: %v", e.StatusCode, e.Err)
+	}
+	return fmt.Sprintf("invalid response from Couchbase Server: %v", e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// temporary reports whether the request may succeed if it is retried.
+func (e *Error) temporary() bool {
+	switch e.Kind {
+	case ErrorKindDNS:
+		var dnsErr *net.DNSError
+		return errors.As(e.Err, &dnsErr) && !dnsErr.IsNotFound
+	case ErrorKindConnect:
+		return true
+	case ErrorKindHTTP:
+		return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+	}
+	return false
+}
+
+// Client contacts Couchbase Server.
+type Client struct {
+	opts   ClientOptions
+	client *http.Client
+}
+
+// NewClient returns a client that connects to Couchbase Server with the given TLS config, or the default one if it is
+// nil.
+func NewClient(opts ClientOptions, tlsConfig *tls.Config) *Client {
+	if opts.ConnectTimeout == 0 {
+		opts.ConnectTimeout = DefaultConnectTimeout
+	}
+	if opts.RequestTimeout == 0 {
+		opts.RequestTimeout = DefaultRequestTimeout
+	}
+	if opts.MaxAttempts == 0 {
+		opts.MaxAttempts = DefaultMaxAttempts
+	}
+	if opts.RetryBackoff == 0 {
+		opts.RetryBackoff = DefaultRetryBackoff
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   opts.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = opts.ConnectTimeout
+	transport.TLSClientConfig = tlsConfig
+	// Clients are short-lived, so connections kept open for them would only linger
+	transport.DisableKeepAlives = true
+	return &Client{
+		opts: opts,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   opts.RequestTimeout,
+		},
+	}
+}
+
+// HTTPClient returns the underlying HTTP client, for requests that should not be retried.
+func (c *Client) HTTPClient() *http.Client {
+	return c.client
+}
+
+// getJSON fetches a URL and decodes the JSON it returns into v, retrying with backoff for as long as the failure may
+// be temporary and the context is not done.
+func (c *Client) getJSON(ctx context.Context, url, username, password string, v interface{}) error {
+	backoff := c.opts.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := c.getJSONOnce(ctx, url, username, password, v)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("stopped contacting Couchbase Server: %w", ctx.Err())
+		}
+		var cbErr *Error
+		if attempt >= c.opts.MaxAttempts || !errors.As(err, &cbErr) || !cbErr.temporary() {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("stopped contacting Couchbase Server: %w", ctx.Err())
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) getJSONOnce(ctx context.Context, url, username, password string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("could not create HTTP request: %w", err)
+	}
+	// Without a username, the client certificate in the TLS config authenticates us instead
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return &Error{Kind: transportErrorKind(err), Err: err}
+	}
+
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return &Error{Kind: ErrorKindConnect, Err: fmt.Errorf("failed to read body: %w", err)}
+	}
+
+	switch {
+	case res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden:
+		return &Error{Kind: ErrorKindAuth, StatusCode: res.StatusCode, Err: errors.New(string(body))}
+	case res.StatusCode != http.StatusOK:
+		return &Error{Kind: ErrorKindHTTP, StatusCode: res.StatusCode, Err: errors.New(string(body))}
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return &Error{Kind: ErrorKindHTTP, Err: fmt.Errorf("failed to parse body: %w", err)}
+	}
+	return nil
+}
+
+// transportErrorKind returns how a request failed when there was no response.
+func transportErrorKind(err error) ErrorKind {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return ErrorKindDNS
+	}
+	if IsTLSError(err) {
+		return ErrorKindTLS
+	}
+	return ErrorKindConnect
+}
+
+// IsTLSError reports whether a request failed because the TLS connection could not be established or verified, as
+// opposed to the server not being reachable at all.
+func IsTLSError(err error) bool {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		hostname         x509.HostnameError
+		invalid          x509.CertificateInvalidError
+		recordHeader     tls.RecordHeaderError
+		opErr            *net.OpError
+	)
+	if errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &invalid) ||
+		errors.As(err, &recordHeader) {
+		return true
+	}
+	// TLS alerts, such as the server rejecting our client certificate, are only distinguished by their operation
+	return errors.As(err, &opErr) && (opErr.Op == "remote error" || opErr.Op == "local error") &&
+		strings.HasPrefix(opErr.Err.Error(), "tls: ")
+}
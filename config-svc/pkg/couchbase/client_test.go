@@ -0,0 +1,130 @@
+// Copyright 2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package couchbase
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientErrors(t *testing.T) {
+	opts := ClientOptions{
+		RequestTimeout: time.Second,
+		MaxAttempts:    3,
+		RetryBackoff:   time.Millisecond,
+	}
+
+	// serve responds with the given statuses in turn, and then with an empty list of targets
+	serve := func(t *testing.T, statuses ...int) (*httptest.Server, *int32) {
+		var attempts int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempt := atomic.AddInt32(&attempts, 1)
+			if int(attempt) <= len(statuses) {
+				w.WriteHeader(statuses[attempt-1])
+				return
+			}
+			_, _ = w.Write([]byte("[]"))
+		}))
+		t.Cleanup(server.Close)
+		return server, &attempts
+	}
+	kindOf := func(t *testing.T, err error) ErrorKind {
+		var cbErr *Error
+		require.ErrorAs(t, err, &cbErr)
+		return cbErr.Kind
+	}
+
+	t.Run("RetriesTemporaryFailures", func(t *testing.T) {
+		server, attempts := serve(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+		_, err := NewClient(opts, nil).FetchPrometheusTargets(context.Background(), server.URL, "user", "pass")
+		require.NoError(t, err)
+		require.EqualValues(t, 3, atomic.LoadInt32(attempts))
+	})
+
+	t.Run("GivesUpAfterMaxAttempts", func(t *testing.T) {
+		server, attempts := serve(t, http.StatusInternalServerError, http.StatusInternalServerError,
+			http.StatusInternalServerError)
+		_, err := NewClient(opts, nil).FetchPrometheusTargets(context.Background(), server.URL, "user", "pass")
+		require.Equal(t, ErrorKindHTTP, kindOf(t, err))
+		require.Contains(t, err.Error(), "500")
+		require.EqualValues(t, 3, atomic.LoadInt32(attempts))
+	})
+
+	t.Run("Auth", func(t *testing.T) {
+		server, attempts := serve(t, http.StatusUnauthorized)
+		_, err := NewClient(opts, nil).FetchPrometheusTargets(context.Background(), server.URL, "user", "pass")
+		require.Equal(t, ErrorKindAuth, kindOf(t, err))
+		require.EqualValues(t, 1, atomic.LoadInt32(attempts))
+	})
+
+	t.Run("DNS", func(t *testing.T) {
+		_, err := NewClient(opts, nil).FetchPrometheusTargets(context.Background(), "http://nonexistent.invalid:8091",
+			"user", "pass")
+		require.Equal(t, ErrorKindDNS, kindOf(t, err))
+	})
+
+	t.Run("Connect", func(t *testing.T) {
+		server, _ := serve(t)
+		server.Close()
+		_, err := NewClient(opts, nil).FetchPrometheusTargets(context.Background(), server.URL, "user", "pass")
+		require.Equal(t, ErrorKindConnect, kindOf(t, err))
+	})
+
+	t.Run("TLS", func(t *testing.T) {
+		server := httptest.NewTLSServer(http.NotFoundHandler())
+		defer server.Close()
+		// The test server's certificate is not signed by any CA we trust
+		_, err := NewClient(opts, nil).FetchPrometheusTargets(context.Background(), server.URL, "user", "pass")
+		require.Equal(t, ErrorKindTLS, kindOf(t, err))
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		unblock := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-unblock
+		}))
+		defer server.Close()
+		defer close(unblock)
+
+		timeoutOpts := opts
+		timeoutOpts.RequestTimeout = 50 * time.Millisecond
+		timeoutOpts.MaxAttempts = 1
+		_, err := NewClient(timeoutOpts, nil).FetchPrometheusTargets(context.Background(), server.URL, "user", "pass")
+		require.Equal(t, ErrorKindConnect, kindOf(t, err))
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		unblock := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-unblock
+		}))
+		defer server.Close()
+		defer close(unblock)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		start := time.Now()
+		_, err := NewClient(opts, nil).FetchPrometheusTargets(ctx, server.URL, "user", "pass")
+		require.True(t, errors.Is(err, context.Canceled), err)
+		require.Less(t, time.Since(start), opts.RequestTimeout)
+	})
+}
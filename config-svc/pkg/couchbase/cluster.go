@@ -15,20 +15,26 @@
 package couchbase
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
 	"net"
-	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/couchbase/tools-common/cbvalue"
-	"github.com/labstack/echo/v4"
+	"github.com/couchbaselabs/observability/config-svc/pkg/prometheus"
 )
 
 type Node struct {
-	Hostname           string          `json:"hostname"`
-	Version            cbvalue.Version `json:"version"`
+	Hostname          string          `json:"hostname"`
+	Version           cbvalue.Version `json:"version"`
+	Services          []string        `json:"services,omitempty"`
+	ServerGroup       string          `json:"serverGroup,omitempty"`
+	OTPNode           string          `json:"otpNode,omitempty"`
+	NodeUUID          string          `json:"nodeUUID,omitempty"`
+	ClusterMembership string          `json:"clusterMembership,omitempty"`
+	// Ports are the node's TLS ports, such as httpsMgmt. Its plain management port is part of its hostname.
+	Ports              map[string]int `json:"ports,omitempty"`
 	AlternateAddresses map[string]struct {
 		Hostname string         `json:"hostname"`
 		Ports    map[string]int `json:"ports"`
@@ -38,62 +44,144 @@ type Node struct {
 type PoolsDefault struct {
 	ClusterName string `json:"clusterName"`
 	Nodes       []Node `json:"nodes"`
+	// UUID is the cluster UUID, which is reported by /pools rather than /pools/default.
+	UUID string `json:"-"`
 }
 
-func (n Node) ResolveHostPort(secure bool) (string, int, error) {
-	hostname := n.Hostname
-	mgmtPort := 8091
+type Pools struct {
+	UUID string `json:"uuid"`
+}
 
-	if externalAddr, ok := n.AlternateAddresses["external"]; ok {
-		hostname = externalAddr.Hostname
-		mgmtPort = externalAddr.Ports["mgmt"]
-		if sslPort, ok := externalAddr.Ports["mgmtSSL"]; secure && ok {
-			mgmtPort = sslPort
+// The management ports of Couchbase Server, unless a node reports otherwise.
+const (
+	DefaultMgmtPort    = 8091
+	DefaultMgmtSSLPort = 18091
+)
+
+// Network is the set of addresses a cluster's nodes are contacted on: their own, or the alternate addresses of a
+// network such as one outside Kubernetes.
+type Network string
+
+const (
+	// NetworkAuto chooses the network the way the SDKs do, see PoolsDefault.ResolveNetwork.
+	NetworkAuto Network = "auto"
+	// NetworkDefault is the nodes' own hostnames and ports.
+	NetworkDefault Network = "default"
+	// NetworkExternal is the nodes' external alternate addresses.
+	NetworkExternal Network = "external"
+)
+
+// ResolveNetwork returns the network to contact the nodes on, given the one asked for and the address the cluster was
+// contacted on, with or without a port. As with the SDKs, auto chooses the default network if any node has that
+// hostname, otherwise the alternate network of any node with it, and otherwise the default network.
+func (p *PoolsDefault) ResolveNetwork(network Network, seed string) Network {
+	if network != NetworkAuto && network != "" {
+		return network
+	}
+	seedHostname, _, err := net.SplitHostPort(seed)
+	if err != nil {
+		seedHostname = seed
+	}
+	seedHostname = TrimBrackets(seedHostname)
+	for _, node := range p.Nodes {
+		hostname, _, err := net.SplitHostPort(node.Hostname)
+		if err != nil {
+			hostname = node.Hostname
+		}
+		if TrimBrackets(hostname) == seedHostname {
+			return NetworkDefault
 		}
-	} else {
-		host, port, err := net.SplitHostPort(hostname)
-		if err == nil {
-			hostname = host
-			mgmtPort, err = strconv.Atoi(port)
-			if err != nil {
-				return "", 0, fmt.Errorf("failed to parse CB hostname port: %w", err)
+	}
+	for _, node := range p.Nodes {
+		for name, addr := range node.AlternateAddresses {
+			if TrimBrackets(addr.Hostname) == seedHostname {
+				return Network(name)
 			}
 		}
 	}
-	return hostname, mgmtPort, nil
+	return NetworkDefault
 }
 
-func FetchCouchbaseClusterInfo(scheme, hostname string, port int, username, password string) (*PoolsDefault,
-	error) {
-	// First, fetch the list of targets from CBS
-	req, err := http.NewRequest(
-		http.MethodGet,
-		fmt.Sprintf("%s://%s:%d/pools/default", scheme, hostname, port),
-		nil,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("could not create HTTP request: %w", err)
-	}
-	req.SetBasicAuth(username, password)
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to contact Couchbase Server: %s", err.Error())
+// ResolveHostPort returns the hostname and management port of a node on a network, which must not be auto. IPv6
+// addresses are returned without brackets. It fails if the node has no address on the network, rather than returning
+// one that cannot be contacted.
+func (n Node) ResolveHostPort(network Network, secure bool) (string, int, error) {
+	if network != NetworkDefault {
+		addr, ok := n.AlternateAddresses[string(network)]
+		if !ok {
+			return "", 0, fmt.Errorf("node %s has no %s alternate address", n.Hostname, network)
+		}
+		if addr.Hostname == "" {
+			return "", 0, fmt.Errorf("the %s alternate address of node %s has no hostname", network, n.Hostname)
+		}
+		portName := "mgmt"
+		if secure {
+			portName = "mgmtSSL"
+		}
+		mgmtPort := addr.Ports[portName]
+		if mgmtPort == 0 {
+			return "", 0, fmt.Errorf("the %s alternate address of node %s has no %s port", network, n.Hostname,
+				portName)
+		}
+		return TrimBrackets(addr.Hostname), mgmtPort, nil
 	}
 
-	defer res.Body.Close()
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read Couchbase body: %w", err)
+	hostname := n.Hostname
+	mgmtPort := DefaultMgmtPort
+	host, port, err := net.SplitHostPort(hostname)
+	if err == nil {
+		hostname = host
+		mgmtPort, err = strconv.Atoi(port)
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to parse CB hostname port: %w", err)
+		}
+	}
+	// The hostname only has the plain port
+	if secure {
+		mgmtPort = DefaultMgmtSSLPort
+		if sslPort, ok := n.Ports["httpsMgmt"]; ok {
+			mgmtPort = sslPort
+		}
 	}
+	return TrimBrackets(hostname), mgmtPort, nil
+}
 
-	if res.StatusCode != http.StatusOK {
-		return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Couchbase Server returned non-OK code %d: %s",
-			res.StatusCode, string(body)))
+// TrimBrackets returns a hostname without the brackets around an IPv6 address, which are only needed when it is
+// followed by a port, so that it can be joined with one again by net.JoinHostPort.
+func TrimBrackets(hostname string) string {
+	if strings.HasPrefix(hostname, "[") && strings.HasSuffix(hostname, "]") {
+		return hostname[1 : len(hostname)-1]
 	}
+	return hostname
+}
+
+// FetchCouchbaseClusterInfo fetches the nodes of a cluster from one of them, along with the cluster's name and UUID.
+func (c *Client) FetchCouchbaseClusterInfo(ctx context.Context, scheme, hostname string, port int, username,
+	password string) (*PoolsDefault, error) {
+	baseURL := scheme + "://" + net.JoinHostPort(TrimBrackets(hostname), strconv.Itoa(port))
 
+	// First, fetch the list of targets from CBS
 	var cluster PoolsDefault
-	if err := json.Unmarshal(body, &cluster); err != nil {
-		return nil, fmt.Errorf("failed to parse Couchbase body: %w", err)
+	if err := c.getJSON(ctx, baseURL+"/pools/default", username, password, &cluster); err != nil {
+		return nil, err
 	}
+
+	// The UUID is only available from /pools
+	var pools Pools
+	if err := c.getJSON(ctx, baseURL+"/pools", username, password, &pools); err != nil {
+		return nil, err
+	}
+	cluster.UUID = pools.UUID
 	return &cluster, nil
 }
+
+// FetchPrometheusTargets fetches the targets of a cluster from its Prometheus service discovery endpoint, as returned
+// by /prometheus_sd_config.
+func (c *Client) FetchPrometheusTargets(ctx context.Context, sdURL, username, password string) (
+	[]prometheus.TargetGroup, error) {
+	var groups []prometheus.TargetGroup
+	if err := c.getJSON(ctx, sdURL, username, password, &groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package couchbase
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveNetwork(t *testing.T) {
+	var cluster PoolsDefault
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"nodes": [
+			{
+				"hostname": "cb-0.cb.default.svc:8091",
+				"alternateAddresses": {
+					"external": {"hostname": "cb-0.example.com", "ports": {"mgmt": 30091, "mgmtSSL": 30191}}
+				}
+			},
+			{
+				"hostname": "cb-1.cb.default.svc:8091",
+				"ports": {"httpsMgmt": 28091},
+				"alternateAddresses": {
+					"external": {"hostname": "cb-1.example.com"}
+				}
+			}
+		]
+	}`), &cluster))
+
+	for _, tc := range []struct {
+		name     string
+		network  Network
+		seed     string
+		expected Network
+	}{
+		{"AutoInternal", NetworkAuto, "cb-1.cb.default.svc", NetworkDefault},
+		{"AutoExternal", NetworkAuto, "cb-0.example.com", NetworkExternal},
+		{"AutoNeither", NetworkAuto, "cb.example.com", NetworkDefault},
+		{"Explicit", NetworkExternal, "cb-0.cb.default.svc", NetworkExternal},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, cluster.ResolveNetwork(tc.network, tc.seed))
+		})
+	}
+
+	t.Run("HostPort", func(t *testing.T) {
+		hostname, port, err := cluster.Nodes[0].ResolveHostPort(NetworkDefault, false)
+		require.NoError(t, err)
+		require.Equal(t, "cb-0.cb.default.svc", hostname)
+		require.Equal(t, 8091, port)
+
+		// The hostname only has the plain port
+		hostname, port, err = cluster.Nodes[0].ResolveHostPort(NetworkDefault, true)
+		require.NoError(t, err)
+		require.Equal(t, "cb-0.cb.default.svc", hostname)
+		require.Equal(t, 18091, port)
+
+		hostname, port, err = cluster.Nodes[0].ResolveHostPort(NetworkExternal, true)
+		require.NoError(t, err)
+		require.Equal(t, "cb-0.example.com", hostname)
+		require.Equal(t, 30191, port)
+
+		// Rather than a target on port 0
+		_, _, err = cluster.Nodes[1].ResolveHostPort(NetworkExternal, false)
+		require.EqualError(t, err, "the external alternate address of node cb-1.cb.default.svc:8091 has no mgmt port")
+
+		_, port, err = cluster.Nodes[1].ResolveHostPort(NetworkDefault, true)
+		require.NoError(t, err)
+		require.Equal(t, 28091, port)
+
+		_, _, err = cluster.Nodes[1].ResolveHostPort(NetworkExternal, true)
+		require.EqualError(t, err, "the external alternate address of node cb-1.cb.default.svc:8091 has no mgmtSSL port")
+
+		_, _, err = cluster.Nodes[0].ResolveHostPort("internal", false)
+		require.EqualError(t, err, "node cb-0.cb.default.svc:8091 has no internal alternate address")
+	})
+}
+
+func TestResolveHostPortIPv6(t *testing.T) {
+	var cluster PoolsDefault
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"nodes": [
+			{
+				"hostname": "[fd00::1]:8091",
+				"alternateAddresses": {
+					"external": {"hostname": "2001:db8::1", "ports": {"mgmt": 30091}}
+				}
+			},
+			{
+				"hostname": "[fd00::2]",
+				"alternateAddresses": {
+					"external": {"hostname": "[2001:db8::2]", "ports": {"mgmt": 30091}}
+				}
+			}
+		]
+	}`), &cluster))
+
+	require.Equal(t, NetworkDefault, cluster.ResolveNetwork(NetworkAuto, "[fd00::2]"))
+	require.Equal(t, NetworkExternal, cluster.ResolveNetwork(NetworkAuto, "2001:db8::2"))
+
+	for _, tc := range []struct {
+		node     int
+		network  Network
+		hostname string
+		port     int
+	}{
+		{0, NetworkDefault, "fd00::1", 8091},
+		{0, NetworkExternal, "2001:db8::1", 30091},
+		{1, NetworkDefault, "fd00::2", 8091},
+		{1, NetworkExternal, "2001:db8::2", 30091},
+	} {
+		hostname, port, err := cluster.Nodes[tc.node].ResolveHostPort(tc.network, false)
+		require.NoError(t, err)
+		require.Equal(t, tc.hostname, hostname)
+		require.Equal(t, tc.port, port)
+	}
+}
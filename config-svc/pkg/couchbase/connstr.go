@@ -0,0 +1,137 @@
+// Copyright 2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package couchbase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// The default ports of the data service, which are the ports given in connection strings.
+const (
+	DefaultKVPort    = 11210
+	DefaultKVSSLPort = 11207
+)
+
+// lookupSRV is replaced in tests.
+var lookupSRV = net.DefaultResolver.LookupSRV
+
+// ConnectionString is a parsed Couchbase connection string, such as couchbase://node1,node2?network=external.
+type ConnectionString struct {
+	// TLS is set by the couchbases:// scheme.
+	TLS bool
+	// Hosts are the seed nodes, along with their ports if given (or zero if not). A port is either a default data
+	// service port or a default management port.
+	Hosts []Address
+	// Options are the parameters after the hosts.
+	Options url.Values
+}
+
+// Address is a host in a connection string.
+type Address struct {
+	Host string
+	Port int
+}
+
+// IsConnectionString reports whether s is a connection string rather than a hostname.
+func IsConnectionString(s string) bool {
+	return strings.HasPrefix(s, "couchbase://") || strings.HasPrefix(s, "couchbases://")
+}
+
+// ParseConnectionString parses a connection string of the form couchbase[s]://host[:port][,host[:port]...][?options].
+// Hosts may also be separated by semicolons, and IPv6 addresses must be enclosed in brackets. As with the SDKs, the
+// ports are those of the data service, which the cluster cannot be discovered on. Only the default data service and
+// management ports are accepted, as any other port could be either.
+func ParseConnectionString(connStr string) (*ConnectionString, error) {
+	var cs ConnectionString
+	var rest string
+	switch {
+	case strings.HasPrefix(connStr, "couchbase://"):
+		rest = strings.TrimPrefix(connStr, "couchbase://")
+	case strings.HasPrefix(connStr, "couchbases://"):
+		cs.TLS = true
+		rest = strings.TrimPrefix(connStr, "couchbases://")
+	default:
+		return nil, errors.New("connection string must start with couchbase:// or couchbases://")
+	}
+
+	hosts := rest
+	if i := strings.IndexAny(rest, "/?"); i != -1 {
+		hosts = rest[:i]
+		options, err := url.ParseQuery(strings.TrimLeft(rest[i:], "/?"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid connection string options: %w", err)
+		}
+		cs.Options = options
+	}
+	for _, host := range strings.FieldsFunc(hosts, func(r rune) bool { return r == ',' || r == ';' }) {
+		hostname, portStr, err := net.SplitHostPort(host)
+		if err != nil {
+			// Without a port there is nothing to split off
+			cs.Hosts = append(cs.Hosts, Address{Host: TrimBrackets(host)})
+			continue
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid port in connection string host %q", host)
+		}
+		switch port {
+		case DefaultKVPort, DefaultKVSSLPort, DefaultMgmtPort, DefaultMgmtSSLPort:
+		default:
+			return nil, fmt.Errorf("port %d of connection string host %q is not a default data service or management "+
+				"port, give the host without it and set the management port instead", port, host)
+		}
+		cs.Hosts = append(cs.Hosts, Address{Host: hostname, Port: port})
+	}
+	if len(cs.Hosts) == 0 {
+		return nil, errors.New("connection string has no hosts")
+	}
+	return &cs, nil
+}
+
+// Seeds returns the addresses to contact the cluster on, using mgmtPort for the hosts without a port or with a data
+// service port. As with the SDKs, a single hostname without a port is first looked up as the name of DNS SRV records
+// listing the nodes, and used as is if there are none. The ports of the records are those of the data service, so
+// mgmtPort is used instead.
+func (cs *ConnectionString) Seeds(ctx context.Context, mgmtPort int) []string {
+	if len(cs.Hosts) == 1 && cs.Hosts[0].Port == 0 && net.ParseIP(cs.Hosts[0].Host) == nil {
+		service := "couchbase"
+		if cs.TLS {
+			service = "couchbases"
+		}
+		if _, records, err := lookupSRV(ctx, service, "tcp", cs.Hosts[0].Host); err == nil && len(records) > 0 {
+			seeds := make([]string, 0, len(records))
+			for _, record := range records {
+				seeds = append(seeds, net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(mgmtPort)))
+			}
+			return seeds
+		}
+	}
+
+	seeds := make([]string, 0, len(cs.Hosts))
+	for _, host := range cs.Hosts {
+		port := host.Port
+		if port == 0 || port == DefaultKVPort || port == DefaultKVSSLPort {
+			port = mgmtPort
+		}
+		seeds = append(seeds, net.JoinHostPort(host.Host, strconv.Itoa(port)))
+	}
+	return seeds
+}
@@ -0,0 +1,94 @@
+// Copyright 2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package couchbase
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConnectionString(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		connStr  string
+		expected ConnectionString
+	}{
+		{
+			name:     "Single",
+			connStr:  "couchbase://cb.example.com",
+			expected: ConnectionString{Hosts: []Address{{Host: "cb.example.com"}}},
+		},
+		{
+			name:    "Multiple",
+			connStr: "couchbases://node1:11207,node2:18091;[fd00::3]",
+			expected: ConnectionString{
+				TLS:   true,
+				Hosts: []Address{{Host: "node1", Port: 11207}, {Host: "node2", Port: 18091}, {Host: "fd00::3"}},
+			},
+		},
+		{
+			name:    "Options",
+			connStr: "couchbase://node1,node2?network=external",
+			expected: ConnectionString{
+				Hosts:   []Address{{Host: "node1"}, {Host: "node2"}},
+				Options: map[string][]string{"network": {"external"}},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			connStr, err := ParseConnectionString(tc.connStr)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, *connStr)
+		})
+	}
+
+	for _, connStr := range []string{"http://node1", "couchbase://", "couchbase://node1:kv", "couchbase://node1:12000"} {
+		_, err := ParseConnectionString(connStr)
+		require.Error(t, err, connStr)
+	}
+}
+
+func TestConnectionStringSeeds(t *testing.T) {
+	defer func(lookup func(context.Context, string, string, string) (string, []*net.SRV, error)) {
+		lookupSRV = lookup
+	}(lookupSRV)
+	lookupSRV = func(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		if service != "couchbases" || proto != "tcp" || name != "cb.example.com" {
+			return "", nil, errors.New("no such host")
+		}
+		return "_couchbases._tcp.cb.example.com.", []*net.SRV{
+			{Target: "node1.cb.example.com.", Port: 11207},
+			{Target: "node2.cb.example.com.", Port: 11207},
+		}, nil
+	}
+
+	seeds := func(connStr string) []string {
+		cs, err := ParseConnectionString(connStr)
+		require.NoError(t, err)
+		return cs.Seeds(context.Background(), 18091)
+	}
+	require.Equal(t, []string{"node1.cb.example.com:18091", "node2.cb.example.com:18091"},
+		seeds("couchbases://cb.example.com"))
+	// Without any records, the hostname is a node itself
+	require.Equal(t, []string{"cb.example.com:18091"}, seeds("couchbase://cb.example.com"))
+	require.Equal(t, []string{"node1:18091", "node2:8091", "[fd00::3]:18091"},
+		seeds("couchbases://node1,node2:8091,[fd00::3]"))
+	// The data service ports are those of the SDKs, rather than the management port
+	require.Equal(t, []string{"node1:18091", "[fd00::3]:18091"}, seeds("couchbases://node1:11207,[fd00::3]:11210"))
+}
@@ -0,0 +1,51 @@
+// Copyright 2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// CheckConfig runs promtool over contents, so that a config Prometheus would refuse never replaces the live one.
+// Paths in the config are relative to the config file, so the check is done on a copy next to cfgPath.
+func CheckConfig(promtoolPath, cfgPath string, contents []byte) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(cfgPath), "."+filepath.Base(cfgPath)+".check")
+	if err != nil {
+		return fmt.Errorf("failed to create config to check: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(contents); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to write config to check: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to close config to check: %w", err)
+	}
+
+	output, err := exec.Command(promtoolPath, "check", "config", tmpFile.Name()).CombinedOutput()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("config is invalid: %s", strings.TrimSpace(string(output)))
+		}
+		return fmt.Errorf("failed to run promtool: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+// Copyright 2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// reloadTimeout bounds a reload, which can take a while on a busy Prometheus.
+const reloadTimeout = time.Minute
+
+// Client talks to the parts of the Prometheus HTTP API needed to apply configuration changes.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewClient creates a client for the Prometheus at baseURL, including any path prefix it is served under.
+func NewClient(baseURL string) *Client {
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{Timeout: reloadTimeout},
+	}
+}
+
+// Reload asks Prometheus to reload its configuration, returning the reason if it could not.
+func (c *Client) Reload(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/-/reload", nil)
+	if err != nil {
+		return fmt.Errorf("could not create reload request: %w", err)
+	}
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to contact Prometheus: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("reload failed (%d): %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// LoadedConfig returns the configuration Prometheus is currently running with, as YAML. Prometheus fills in defaults
+// and hides secrets, so it will not be identical to the file it was loaded from.
+func (c *Client) LoadedConfig(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/api/v1/status/config", nil)
+	if err != nil {
+		return "", fmt.Errorf("could not create config request: %w", err)
+	}
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to contact Prometheus: %w", err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read Prometheus response: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d from Prometheus: %s", res.StatusCode, string(body))
+	}
+
+	var status struct {
+		Data struct {
+			YAML string `json:"yaml"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return "", fmt.Errorf("failed to parse Prometheus response: %w", err)
+	}
+	return status.Data.YAML, nil
+}
+
+// ReloadAndVerify reloads Prometheus and checks that it is now running the configuration written to configPath, by
+// comparing the targets of each job, as they survive Prometheus filling in defaults and hiding secrets.
+func (c *Client) ReloadAndVerify(ctx context.Context, configPath string, written []byte) error {
+	if err := c.Reload(ctx); err != nil {
+		return err
+	}
+	loaded, err := c.LoadedConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Prometheus reports the files of file service discovery relative to the directory of its config
+	dir := filepath.Dir(configPath)
+	want, err := jobTargets(written, dir)
+	if err != nil {
+		return err
+	}
+	got, err := jobTargets([]byte(loaded), dir)
+	if err != nil {
+		return fmt.Errorf("failed to parse loaded config: %w", err)
+	}
+	wantJobs, gotJobs := sortedKeys(want), sortedKeys(got)
+	if strings.Join(wantJobs, ",") != strings.Join(gotJobs, ",") {
+		return fmt.Errorf("loaded config has jobs [%s] instead of [%s]", strings.Join(gotJobs, ", "),
+			strings.Join(wantJobs, ", "))
+	}
+	for _, job := range wantJobs {
+		if strings.Join(want[job], ",") != strings.Join(got[job], ",") {
+			return fmt.Errorf("loaded config has targets [%s] instead of [%s] in job %s",
+				strings.Join(got[job], ", "), strings.Join(want[job], ", "), job)
+		}
+	}
+	return nil
+}
+
+// jobTargets returns the sorted targets of each job of a Prometheus configuration, along with where any others are
+// discovered from. Relative file service discovery paths are resolved against dir, as Prometheus does.
+func jobTargets(contents []byte, dir string) (map[string][]string, error) {
+	var cfg struct {
+		ScrapeConfigs []struct {
+			JobName       string `yaml:"job_name"`
+			StaticConfigs []struct {
+				Targets []string `yaml:"targets"`
+			} `yaml:"static_configs"`
+			HTTPSDConfigs []struct {
+				URL string `yaml:"url"`
+			} `yaml:"http_sd_configs"`
+			FileSDConfigs []struct {
+				Files []string `yaml:"files"`
+			} `yaml:"file_sd_configs"`
+		} `yaml:"scrape_configs"`
+	}
+	if err := yaml.Unmarshal(contents, &cfg); err != nil {
+		return nil, err
+	}
+	jobs := make(map[string][]string, len(cfg.ScrapeConfigs))
+	for _, sc := range cfg.ScrapeConfigs {
+		var targets []string
+		for _, static := range sc.StaticConfigs {
+			targets = append(targets, static.Targets...)
+		}
+		for _, sdConfig := range sc.HTTPSDConfigs {
+			targets = append(targets, sdConfig.URL)
+		}
+		for _, fileSDConfig := range sc.FileSDConfigs {
+			for _, file := range fileSDConfig.Files {
+				if !filepath.IsAbs(file) {
+					file = filepath.Join(dir, file)
+				}
+				targets = append(targets, file)
+			}
+		}
+		sort.Strings(targets)
+		jobs[sc.JobName] = targets
+	}
+	return jobs, nil
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
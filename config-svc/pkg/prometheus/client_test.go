@@ -0,0 +1,66 @@
+// Copyright 2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/couchbaselabs/observability/config-svc/pkg/prometheus/prometheustest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloadAndVerify(t *testing.T) {
+	const written = `scrape_configs:
+    - job_name: a
+    - job_name: b
+      static_configs:
+        - targets:
+            - test1:8091
+`
+	cfgPath := filepath.Join(t.TempDir(), "prometheus.yml")
+	server := prometheustest.NewServer(t, cfgPath)
+	client := NewClient(server.URL + "/")
+
+	t.Run("Loaded", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(cfgPath, []byte(written), 0o600))
+		require.NoError(t, client.ReloadAndVerify(context.Background(), cfgPath, []byte(written)))
+	})
+
+	t.Run("LoadedSomethingElse", func(t *testing.T) {
+		// As if Prometheus were reading a different file to the one we wrote
+		require.NoError(t, os.WriteFile(cfgPath, []byte("scrape_configs:\n    - job_name: a\n"), 0o600))
+		err := client.ReloadAndVerify(context.Background(), cfgPath, []byte(written))
+		require.EqualError(t, err, "loaded config has jobs [a] instead of [a, b]")
+	})
+
+	t.Run("LoadedOtherTargets", func(t *testing.T) {
+		// As if Prometheus had loaded the file while it was being changed by someone else
+		loaded := "scrape_configs:\n    - job_name: a\n    - job_name: b\n"
+		require.NoError(t, os.WriteFile(cfgPath, []byte(loaded), 0o600))
+		err := client.ReloadAndVerify(context.Background(), cfgPath, []byte(written))
+		require.EqualError(t, err, "loaded config has targets [] instead of [test1:8091] in job b")
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		duplicated := written + "    - job_name: a\n"
+		require.NoError(t, os.WriteFile(cfgPath, []byte(duplicated), 0o600))
+		err := client.ReloadAndVerify(context.Background(), cfgPath, []byte(duplicated))
+		require.EqualError(t, err, `reload failed (500): failed to reload config: found multiple scrape configs with `+
+			`job name "a"`)
+	})
+}
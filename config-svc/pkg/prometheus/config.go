@@ -16,29 +16,79 @@ package prometheus
 
 import (
 	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 const (
 	managedMarkerComment = "CMOS managed"
+	// partAnnotation marks the managed scrape configs that are part of another, with the same ID, rather than being
+	// managed scrape configs of their own.
+	partAnnotation = "part"
+	exporterPart   = "exporter"
 )
 
 type BasicAuthConfig struct {
 	Username string `yaml:"username"`
-	Password string `yaml:"password"`
+	Password string `yaml:"password,omitempty"`
+	// PasswordFile is read for the password instead, to keep it out of the config.
+	PasswordFile string `yaml:"password_file,omitempty"`
+}
+
+// Credentials returns the username and password, reading the password from the password file if there is one. Both
+// are empty if there is no basic auth.
+func (c *BasicAuthConfig) Credentials() (string, string, error) {
+	if c == nil {
+		return "", "", nil
+	}
+	if c.PasswordFile == "" {
+		return c.Username, c.Password, nil
+	}
+	password, err := os.ReadFile(c.PasswordFile)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read password file: %w", err)
+	}
+	// Prometheus strips trailing whitespace too, as files tend to end in a newline
+	return c.Username, strings.TrimSpace(string(password)), nil
 }
 
 type HTTPClientConfig struct {
-	BasicAuth BasicAuthConfig `yaml:"basic_auth"`
+	// BasicAuth is nil for clusters authenticated to with a client certificate.
+	BasicAuth *BasicAuthConfig `yaml:"basic_auth,omitempty"`
+	TLSConfig *TLSConfig       `yaml:"tls_config,omitempty"`
 }
 
 type ScrapeConfig struct {
+	// ID is the stable identity of a managed ScrapeConfig. It is stored in the marker comment rather than in the
+	// Prometheus config itself, and is empty for ScrapeConfigs written before IDs were introduced.
+	ID string `yaml:"-"`
+	// Annotations hold any other state CMOS needs to keep about a managed ScrapeConfig. Like the ID they are stored in
+	// the marker comment, so they are only kept if the ID is set.
+	Annotations map[string]string `yaml:"-"`
 	// The job name to which the job label is set by default.
 	JobName          string           `yaml:"job_name"`
 	MetricsPath      string           `yaml:"metrics_path"`
+	Scheme           string           `yaml:"scheme,omitempty"`
 	HTTPClientConfig HTTPClientConfig `yaml:",inline"`
 	StaticConfigs    []StaticConfig   `yaml:"static_configs,omitempty"`
+	HTTPSDConfigs    []HTTPSDConfig   `yaml:"http_sd_configs,omitempty"`
+	RelabelConfigs   []RelabelConfig  `yaml:"relabel_configs,omitempty"`
+	// Exporter scrapes the exporters running alongside the nodes of a cluster that are older than 7.0, which have
+	// credentials of their own. It is written as a separate managed scrape config with the same ID, straight after
+	// this one.
+	Exporter *ScrapeConfig `yaml:"-"`
+}
+
+// managedID returns the ID a managed ScrapeConfig is identified by, which is its job name if it has no ID.
+func (sc *ScrapeConfig) managedID() string {
+	if sc.ID == "" {
+		return sc.JobName
+	}
+	return sc.ID
 }
 
 type StaticConfig struct {
@@ -46,6 +96,28 @@ type StaticConfig struct {
 	Labels  map[string]string `yaml:"labels"`
 }
 
+// HTTPSDConfig discovers targets from an HTTP endpoint that returns them in the same format as file_sd_configs.
+type HTTPSDConfig struct {
+	URL              string           `yaml:"url"`
+	RefreshInterval  string           `yaml:"refresh_interval,omitempty"`
+	HTTPClientConfig HTTPClientConfig `yaml:",inline"`
+}
+
+// TargetGroup is a group of targets in the format used by file and HTTP service discovery.
+type TargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// RelabelConfig is a subset of Prometheus' relabel_config, enough to set a label to a fixed value.
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels,omitempty"`
+	Regex        string   `yaml:"regex,omitempty"`
+	TargetLabel  string   `yaml:"target_label,omitempty"`
+	Replacement  string   `yaml:"replacement,omitempty"`
+	Action       string   `yaml:"action,omitempty"`
+}
+
 // Configuration is a subset of prometheus' config struct with special YAML marshal/unmarshal handling.
 // Adding a ScrapeConfig to the ScrapeConfigs slice will mean it will be marshaled in the resulting YAML with a special
 // marker comment. Unmarshalling the YAML will yield the "added" scrape_configs in ScrapeConfigs, but keep the user's
@@ -77,18 +149,49 @@ func (c *Configuration) UnmarshalYAML(value *yaml.Node) error {
 	}
 	c.baseScrapeConfigs = make([]*yaml.Node, 0)
 	c.ScrapeConfigs = make([]*ScrapeConfig, 0)
+	var exporters []*yaml.Node
 	for _, sc := range scrapeConfigsSeq.Content {
 		// If it has the marker head comment, decode it as a ScrapeConfig struct, otherwise save it in baseScrapeConfigs
-		if sc.Tag == "!!map" && (sc.HeadComment == managedMarkerComment || sc.HeadComment == "# "+managedMarkerComment) {
+		if id, annotations, ok := parseManagedMarker(sc.HeadComment); sc.Tag == "!!map" && ok {
+			if annotations[partAnnotation] == exporterPart {
+				exporters = append(exporters, sc)
+				continue
+			}
 			var val ScrapeConfig
 			if err := sc.Decode(&val); err != nil {
 				return fmt.Errorf("couldn't unmarshal ScrapeConfig: %w", err)
 			}
+			val.ID = id
+			val.Annotations = annotations
 			c.ScrapeConfigs = append(c.ScrapeConfigs, &val)
 		} else {
 			c.baseScrapeConfigs = append(c.baseScrapeConfigs, sc)
 		}
 	}
+	for _, node := range exporters {
+		if err := c.attachExporter(node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attachExporter decodes the exporter part of a managed ScrapeConfig into the ScrapeConfig it belongs to. One that no
+// longer belongs to any is kept as it is, like the user's own.
+func (c *Configuration) attachExporter(node *yaml.Node) error {
+	id, _, _ := parseManagedMarker(node.HeadComment)
+	for _, sc := range c.ScrapeConfigs {
+		if sc.managedID() != id || sc.Exporter != nil {
+			continue
+		}
+		var exporter ScrapeConfig
+		if err := node.Decode(&exporter); err != nil {
+			return fmt.Errorf("couldn't unmarshal exporter ScrapeConfig: %w", err)
+		}
+		sc.Exporter = &exporter
+		return nil
+	}
+	c.baseScrapeConfigs = append(c.baseScrapeConfigs, node)
 	return nil
 }
 
@@ -103,8 +206,21 @@ func (c *Configuration) MarshalYAML() (interface{}, error) {
 		if err := node.Encode(sc); err != nil {
 			return nil, fmt.Errorf("failed to marshal ScrapeConfig: %w", err)
 		}
-		node.HeadComment = managedMarkerComment
+		node.HeadComment = formatManagedMarker(sc)
 		scrapeConfigs.Content = append(scrapeConfigs.Content, node)
+
+		if sc.Exporter == nil {
+			continue
+		}
+		exporterNode := new(yaml.Node)
+		if err := exporterNode.Encode(sc.Exporter); err != nil {
+			return nil, fmt.Errorf("failed to marshal exporter ScrapeConfig: %w", err)
+		}
+		exporterNode.HeadComment = formatManagedMarker(&ScrapeConfig{
+			ID:          sc.managedID(),
+			Annotations: map[string]string{partAnnotation: exporterPart},
+		})
+		scrapeConfigs.Content = append(scrapeConfigs.Content, exporterNode)
 	}
 
 	output := c.base
@@ -134,3 +250,55 @@ func (c *Configuration) MarshalYAML() (interface{}, error) {
 	}
 	return output, nil
 }
+
+// formatManagedMarker returns the marker comment for the given ScrapeConfig, which is "CMOS managed" or
+// "CMOS managed: <id> [<key>=<value>...]".
+func formatManagedMarker(sc *ScrapeConfig) string {
+	if sc.ID == "" {
+		return managedMarkerComment
+	}
+	parts := []string{managedMarkerComment + ": " + sc.ID}
+	keys := make([]string, 0, len(sc.Annotations))
+	for key := range sc.Annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		parts = append(parts, url.PathEscape(key)+"="+url.PathEscape(sc.Annotations[key]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// parseManagedMarker checks whether the given head comment is the managed marker, and if so returns the ID and
+// annotations stored in it (if any).
+func parseManagedMarker(comment string) (string, map[string]string, bool) {
+	comment = strings.TrimPrefix(comment, "# ")
+	if comment == managedMarkerComment {
+		return "", nil, true
+	}
+	rest := strings.TrimPrefix(comment, managedMarkerComment+": ")
+	if rest == comment {
+		return "", nil, false
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return "", nil, false
+	}
+	var annotations map[string]string
+	for _, field := range fields[1:] {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return "", nil, false
+		}
+		key, keyErr := url.PathUnescape(kv[0])
+		value, valueErr := url.PathUnescape(kv[1])
+		if keyErr != nil || valueErr != nil {
+			return "", nil, false
+		}
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[key] = value
+	}
+	return fields[0], annotations, true
+}
@@ -64,15 +64,105 @@ func TestConfigAddNewScrape(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, testYaml+`    # CMOS managed
     - job_name: added
+      metrics_path: /metrics
+      static_configs:
+        - targets:
+            - test
+          labels: {}
+`, string(marshaled))
+}
+
+func TestConfigManagedID(t *testing.T) {
+	const managedYaml = testYaml + `    # CMOS managed: 6d3e2b8a seed=test:8091
+    - job_name: couchbase-server-managed-6d3e2b8a
       metrics_path: /metrics
       basic_auth:
         username: ""
-        password: ""
       static_configs:
         - targets:
             - test
           labels: {}
-`, string(marshaled))
+    # CMOS managed
+    - job_name: couchbase-server-managed-2
+      metrics_path: /metrics
+      basic_auth:
+        username: ""
+      static_configs:
+        - targets:
+            - test
+          labels: {}
+`
+	var value Configuration
+	err := yaml.Unmarshal([]byte(managedYaml), &value)
+	require.NoError(t, err)
+	require.Len(t, value.ScrapeConfigs, 2)
+	require.Equal(t, "6d3e2b8a", value.ScrapeConfigs[0].ID)
+	require.Equal(t, map[string]string{"seed": "test:8091"}, value.ScrapeConfigs[0].Annotations)
+	require.Equal(t, "", value.ScrapeConfigs[1].ID)
+
+	marshaled, err := yaml.Marshal(&value)
+	require.NoError(t, err)
+	require.Equal(t, managedYaml, string(marshaled))
+}
+
+func TestConfigExporter(t *testing.T) {
+	const exporterYaml = testYaml + `    # CMOS managed: 6d3e2b8a seed=test:8091
+    - job_name: couchbase-server-managed-6d3e2b8a
+      metrics_path: /metrics
+      basic_auth:
+        username: Administrator
+        password: password
+      static_configs:
+        - targets:
+            - test:8091
+          labels:
+            cluster_name: test
+    # CMOS managed: 6d3e2b8a part=exporter
+    - job_name: couchbase-server-managed-6d3e2b8a-exporter
+      metrics_path: /metrics
+      static_configs:
+        - targets:
+            - old:9091
+          labels:
+            cluster_name: test
+    # CMOS managed
+    - job_name: couchbase-server-managed-2
+      metrics_path: /metrics
+      static_configs:
+        - targets:
+            - test
+          labels: {}
+    # CMOS managed: couchbase-server-managed-2 part=exporter
+    - job_name: couchbase-server-managed-2-exporter
+      metrics_path: /metrics
+      static_configs:
+        - targets:
+            - old
+          labels: {}
+`
+	var value Configuration
+	err := yaml.Unmarshal([]byte(exporterYaml), &value)
+	require.NoError(t, err)
+	require.Len(t, value.ScrapeConfigs, 2)
+	require.Equal(t, "couchbase-server-managed-6d3e2b8a-exporter", value.ScrapeConfigs[0].Exporter.JobName)
+	require.Equal(t, []string{"old:9091"}, value.ScrapeConfigs[0].Exporter.StaticConfigs[0].Targets)
+	// Scrape configs written before IDs were introduced are matched up by their job name
+	require.Equal(t, "couchbase-server-managed-2-exporter", value.ScrapeConfigs[1].Exporter.JobName)
+
+	marshaled, err := yaml.Marshal(&value)
+	require.NoError(t, err)
+	require.Equal(t, exporterYaml, string(marshaled))
+
+	t.Run("Orphaned", func(t *testing.T) {
+		// Once the cluster it belongs to is gone, it is left alone like the user's own
+		orphanYaml := testYaml + `    # CMOS managed: 6d3e2b8a part=exporter
+    - job_name: couchbase-server-managed-6d3e2b8a-exporter
+      metrics_path: /metrics
+`
+		var value Configuration
+		require.NoError(t, yaml.Unmarshal([]byte(orphanYaml), &value))
+		require.Empty(t, value.ScrapeConfigs)
+	})
 }
 
 func TestInvalidYAML(t *testing.T) {
@@ -0,0 +1,160 @@
+// Copyright 2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package prometheustest provides a fake Prometheus for the tests of the packages that talk to it.
+package prometheustest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Server is a fake Prometheus for tests. Like the real one, it loads its config file when told to reload, and
+// refuses configs it cannot parse.
+type Server struct {
+	*httptest.Server
+
+	configPath string
+
+	mu          sync.Mutex
+	loaded      string
+	reloads     int
+	reloadError string
+}
+
+// NewServer starts a fake Prometheus that loads its config from configPath. It is closed when the test finishes.
+func NewServer(t *testing.T, configPath string) *Server {
+	server := &Server{configPath: configPath}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/-/reload", server.handleReload)
+	mux.HandleFunc("/api/v1/status/config", server.handleStatusConfig)
+	server.Server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// FailReloads makes every following reload fail with the given message, or succeed again if it is empty.
+func (s *Server) FailReloads(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reloadError = msg
+}
+
+// Reloads returns how many times a reload has been requested.
+func (s *Server) Reloads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reloads
+}
+
+// Loaded returns the config the fake Prometheus is running with.
+func (s *Server) Loaded() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loaded
+}
+
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reloads++
+
+	if s.reloadError != "" {
+		http.Error(w, "failed to reload config: "+s.reloadError, http.StatusInternalServerError)
+		return
+	}
+	contents, err := os.ReadFile(s.configPath)
+	if err != nil {
+		http.Error(w, "failed to reload config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := checkJobNames(contents); err != nil {
+		http.Error(w, "failed to reload config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	loaded, err := absoluteFileSDPaths(contents, filepath.Dir(s.configPath))
+	if err != nil {
+		http.Error(w, "failed to reload config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.loaded = string(loaded)
+}
+
+func (s *Server) handleStatusConfig(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"data": map[string]string{
+			"yaml": s.loaded,
+		},
+	})
+}
+
+// absoluteFileSDPaths resolves the relative files of file service discovery against dir, as Prometheus does when it
+// loads its config.
+func absoluteFileSDPaths(contents []byte, dir string) ([]byte, error) {
+	var cfg map[string]interface{}
+	if err := yaml.Unmarshal(contents, &cfg); err != nil {
+		return nil, err
+	}
+	scrapeConfigs, _ := cfg["scrape_configs"].([]interface{})
+	for _, sc := range scrapeConfigs {
+		scMap, _ := sc.(map[string]interface{})
+		fileSDConfigs, _ := scMap["file_sd_configs"].([]interface{})
+		for _, fileSDConfig := range fileSDConfigs {
+			fileSDMap, _ := fileSDConfig.(map[string]interface{})
+			files, _ := fileSDMap["files"].([]interface{})
+			for i, file := range files {
+				if path, ok := file.(string); ok && !filepath.IsAbs(path) {
+					files[i] = filepath.Join(dir, path)
+				}
+			}
+		}
+	}
+	return yaml.Marshal(cfg)
+}
+
+// checkJobNames rejects configs that do not parse or have duplicate job names, as Prometheus does.
+func checkJobNames(contents []byte) error {
+	var cfg struct {
+		ScrapeConfigs []struct {
+			JobName string `yaml:"job_name"`
+		} `yaml:"scrape_configs"`
+	}
+	if err := yaml.Unmarshal(contents, &cfg); err != nil {
+		return err
+	}
+	seen := make(map[string]bool, len(cfg.ScrapeConfigs))
+	for _, sc := range cfg.ScrapeConfigs {
+		if seen[sc.JobName] {
+			return fmt.Errorf("found multiple scrape configs with job name %q", sc.JobName)
+		}
+		seen[sc.JobName] = true
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file  except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the  License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSConfig is Prometheus' tls_config. The certificates can only be given as files by the Prometheus versions we
+// support.
+type TLSConfig struct {
+	CAFile             string `yaml:"ca_file,omitempty"`
+	CertFile           string `yaml:"cert_file,omitempty"`
+	KeyFile            string `yaml:"key_file,omitempty"`
+	ServerName         string `yaml:"server_name,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
+}
+
+// ClientConfig returns the equivalent Go TLS config, so that we connect the same way Prometheus does.
+func (c *TLSConfig) ClientConfig() (*tls.Config, error) {
+	if c == nil {
+		return nil, nil
+	}
+	var (
+		caPEM, certPEM, keyPEM []byte
+		err                    error
+	)
+	if c.CAFile != "" {
+		if caPEM, err = os.ReadFile(c.CAFile); err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+	}
+	if c.CertFile != "" {
+		if certPEM, err = os.ReadFile(c.CertFile); err != nil {
+			return nil, fmt.Errorf("failed to read client certificate: %w", err)
+		}
+	}
+	if c.KeyFile != "" {
+		if keyPEM, err = os.ReadFile(c.KeyFile); err != nil {
+			return nil, fmt.Errorf("failed to read client key: %w", err)
+		}
+	}
+	return NewClientTLSConfig(caPEM, certPEM, keyPEM, c.ServerName, c.InsecureSkipVerify)
+}
+
+// NewClientTLSConfig returns a Go TLS config that verifies servers against the PEM encoded CA certificates, or the
+// system ones if there are none, and presents the client certificate if there is one.
+func NewClientTLSConfig(caPEM, certPEM, keyPEM []byte, serverName string, insecureSkipVerify bool) (*tls.Config,
+	error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         serverName,
+		InsecureSkipVerify: insecureSkipVerify, //nolint:gosec
+	}
+	if len(caPEM) > 0 {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("no certificates found in the CA certificate")
+		}
+	}
+	if len(certPEM) > 0 || len(keyPEM) > 0 {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
@@ -20,6 +20,8 @@ export CB_MULTI_ADMIN_PASSWORD=${CB_MULTI_ADMIN_PASSWORD:-password}
 export CB_SERVER_AUTH_USER=${CB_SERVER_AUTH_USER:-Administrator}
 export CB_SERVER_AUTH_PASSWORD=${CB_SERVER_AUTH_PASSWORD:-password}
 export CMOS_HTTP_PATH_PREFIX=${CMOS_HTTP_PATH_PREFIX:-""}
+export CMOS_CFG_HTTP_PATH_PREFIX=${CMOS_CFG_HTTP_PATH_PREFIX:-${CMOS_HTTP_PATH_PREFIX}/config}
+export CMOS_CFG_HTTP_PORT=${CMOS_CFG_HTTP_PORT:-7194}
 
 # To customise the Prometheus configuration used, set these values at launch
 PROMETHEUS_CONFIG_FILE=${PROMETHEUS_CONFIG_FILE:-/etc/prometheus/prometheus-runtime.yml}
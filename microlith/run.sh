@@ -78,6 +78,22 @@ export CMOS_CFG_PATH=${CMOS_CFG_PATH:-/etc/cmos/config.yaml}
 export CMOS_CFG_HTTP_PATH_PREFIX=${CMOS_CFG_HTTP_PATH_PREFIX:-${CMOS_HTTP_PATH_PREFIX}/config}
 export CMOS_CFG_HTTP_HOST=${CMOS_CFG_HTTP_HOST:-127.0.0.1}
 export CMOS_CFG_HTTP_PORT=${CMOS_CFG_HTTP_PORT:-7194}
+export CMOS_CFG_RECONCILE_INTERVAL=${CMOS_CFG_RECONCILE_INTERVAL:-5m}
+# Managed clusters get a scrape job of their own, so that each keeps its own credentials and TLS settings. The http-sd
+# and file-sd modes avoid reloading Prometheus, but scrape every cluster with the $CB_SERVER_AUTH_* credentials.
+export CMOS_CFG_TARGET_MODE=${CMOS_CFG_TARGET_MODE:-prometheus-config}
+export CMOS_CFG_MANAGED_CONFIG_FILE=${CMOS_CFG_MANAGED_CONFIG_FILE:-/etc/cmos/managed-clusters.yml}
+export CMOS_CFG_FILE_SD_DIR=${CMOS_CFG_FILE_SD_DIR:-/etc/prometheus/couchbase/custom}
+# Prometheus serves its API under the same sub-path as its UI
+export CMOS_CFG_PROMETHEUS_URL=${CMOS_CFG_PROMETHEUS_URL-http://localhost:9090${PROMETHEUS_URL_SUBPATH%/}}
+export CMOS_CFG_PROMTOOL=${CMOS_CFG_PROMTOOL-/bin/promtool}
+export CMOS_CFG_HISTORY_DIR=${CMOS_CFG_HISTORY_DIR-/etc/cmos/config-history}
+export CMOS_CFG_HISTORY_SIZE=${CMOS_CFG_HISTORY_SIZE:-50}
+export CMOS_CFG_SECRETS_DIR=${CMOS_CFG_SECRETS_DIR-/etc/cmos/secrets}
+export CMOS_CFG_DISCOVERY_CONNECT_TIMEOUT=${CMOS_CFG_DISCOVERY_CONNECT_TIMEOUT:-5s}
+export CMOS_CFG_DISCOVERY_REQUEST_TIMEOUT=${CMOS_CFG_DISCOVERY_REQUEST_TIMEOUT:-15s}
+export CMOS_CFG_DISCOVERY_ATTEMPTS=${CMOS_CFG_DISCOVERY_ATTEMPTS:-3}
+export CMOS_CFG_DISCOVERY_RETRY_BACKOFF=${CMOS_CFG_DISCOVERY_RETRY_BACKOFF:-500ms}
 
 export CMOS_LOGS_ROOT=${CMOS_LOGS_ROOT:-/logs}
 # Clean up dynamic targets generated